@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/base64"
+	"regexp"
+)
+
+// base64Aware enables rewriting base64_encode(serialize(...)) payloads
+var base64Aware bool
+
+// base64Re matches candidate payloads. The shortest useful payload, a:0:{},
+// is 8 characters long once encoded.
+var base64Re = regexp.MustCompile(`[A-Za-z0-9+/]{8,}={0,2}`)
+
+// replaceAroundBase64 applies the replacements to part, decoding base64
+// payloads that contain PHP serialized data and fixing the lengths inside them.
+// The rest of part is replaced as usual.
+func replaceAroundBase64(part []byte, replacements []*Replacement, esc *escaping) []byte {
+	var rebuilt []byte
+	last := 0
+
+	for _, match := range base64Re.FindAllIndex(part, -1) {
+		rewritten, ok := rewriteBase64Payload(part[match[0]:match[1]], replacements)
+		if !ok {
+			continue
+		}
+
+		rebuilt = append(rebuilt, applyReplacements(part[last:match[0]], replacements, esc)...)
+		rebuilt = append(rebuilt, rewritten...)
+		last = match[1]
+	}
+
+	if last == 0 {
+		return applyReplacements(part, replacements, esc)
+	}
+
+	return append(rebuilt, applyReplacements(part[last:], replacements, esc)...)
+}
+
+// rewriteBase64Payload returns the payload with the replacements applied inside
+// it. ok is false if encoded isn't a base64 encoded serialized value.
+func rewriteBase64Payload(encoded []byte, replacements []*Replacement) ([]byte, bool) {
+	if len(encoded)%4 != 0 {
+		return nil, false
+	}
+
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+	n, err := base64.StdEncoding.Decode(decoded, encoded)
+	if err != nil || !isSerializedStart(decoded[:n]) {
+		return nil, false
+	}
+
+	value, err := parseSerializedExactly(decoded[:n])
+	if err != nil {
+		return nil, false
+	}
+
+	if !value.replaceStrings(replacements) {
+		return encoded, true
+	}
+
+	stats.base64Payloads.Add(1)
+
+	serialized := value.serialize()
+	rewritten := make([]byte, base64.StdEncoding.EncodedLen(len(serialized)))
+	base64.StdEncoding.Encode(rewritten, serialized)
+
+	return rewritten, true
+}
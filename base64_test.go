@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"testing"
+)
+
+func encodeBase64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func TestReplaceBase64Payloads(t *testing.T) {
+	widget := encodeBase64(`a:1:{s:3:"url";s:19:"https://example.com";}`)
+	widgetReplaced := encodeBase64(`a:1:{s:3:"url";s:23:"https://example.org/new";}`)
+	unrelated := encodeBase64(`https://example.com is not serialized`)
+
+	var tests = []struct {
+		testName string
+		in       string
+		out      string
+		payloads int64
+	}{
+		{
+			testName: "payload in a column",
+			in:       fmt.Sprintf(`('%s','https://example.com')`, widget),
+			out:      fmt.Sprintf(`('%s','https://example.org/new')`, widgetReplaced),
+			payloads: 1,
+		},
+		{
+			testName: "payload in a serialized string",
+			in:       fmt.Sprintf(`a:1:{i:0;s:%d:\"%s\";}`, len(widget), widget),
+			out:      fmt.Sprintf(`a:1:{i:0;s:%d:\"%s\";}`, len(widgetReplaced), widgetReplaced),
+			payloads: 1,
+		},
+		{
+			testName: "base64 that isn't serialized data is left alone",
+			in:       fmt.Sprintf(`('%s')`, unrelated),
+			out:      fmt.Sprintf(`('%s')`, unrelated),
+			payloads: 0,
+		},
+	}
+
+	base64Aware = true
+	defer func() { base64Aware = false }()
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			before := stats.base64Payloads.Load()
+
+			in := []byte(test.in)
+			replaced := fixLine(&in, []*Replacement{
+				{
+					From: []byte("https://example.com"),
+					To:   []byte("https://example.org/new"),
+				},
+			})
+
+			if !bytes.Equal(*replaced, []byte(test.out)) {
+				t.Error("Expected:", test.out, "Actual:", string(*replaced))
+			}
+			if payloads := stats.base64Payloads.Load() - before; payloads != test.payloads {
+				t.Error("Expected payloads:", test.payloads, "Actual:", payloads)
+			}
+		})
+	}
+}
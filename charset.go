@@ -0,0 +1,125 @@
+package main
+
+import (
+	"unicode/utf8"
+)
+
+const (
+	charsetLatin1        = "latin1"
+	charsetDoubleEncoded = "double-encoded"
+)
+
+// convertCharset converts the text around and inside serialized data before
+// the replacements are applied to it, nil leaves the text as it is. Lengths are
+// recomputed from the converted bytes like after any other replacement.
+var convertCharset func([]byte) []byte
+
+// convertText applies convertCharset to text, if it is set. Text that isn't
+// replaced, such as JSON object keys, goes through it too, so the output
+// doesn't mix character sets.
+func convertText(text []byte) []byte {
+	if convertCharset == nil {
+		return text
+	}
+	return convertCharset(text)
+}
+
+var charsetConversions = map[string]func([]byte) []byte{
+	charsetLatin1:        latin1ToUTF8,
+	charsetDoubleEncoded: fixDoubleEncoded,
+}
+
+// cp1252 holds the characters of 0x80 to 0x9F in MySQL's latin1, which is
+// Windows-1252 with the five bytes it leaves undefined kept as C1 controls.
+// The other bytes stand for the code point of the same value.
+var cp1252 = [32]rune{
+	'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '\u008d', 'Ž', '\u008f',
+	'\u0090', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '\u009d', 'ž', 'Ÿ',
+}
+
+// cp1252Bytes maps the characters of cp1252 back to their bytes
+var cp1252Bytes = func() map[rune]byte {
+	bytes := make(map[rune]byte, len(cp1252))
+	for i, r := range cp1252 {
+		bytes[r] = byte(0x80 + i)
+	}
+	return bytes
+}()
+
+// latin1ToUTF8 converts text in MySQL's latin1 to UTF-8
+func latin1ToUTF8(text []byte) []byte {
+	converted := make([]byte, 0, len(text)+len(text)/8)
+	for _, c := range text {
+		switch {
+		case c < utf8.RuneSelf:
+			converted = append(converted, c)
+		case c < 0xA0:
+			converted = utf8.AppendRune(converted, cp1252[c-0x80])
+		default:
+			converted = utf8.AppendRune(converted, rune(c))
+		}
+	}
+	return converted
+}
+
+// latin1Byte returns the latin1 byte that stands for r, if there is one
+func latin1Byte(r rune) (byte, bool) {
+	if c, ok := cp1252Bytes[r]; ok {
+		return c, true
+	}
+	if r >= 0xA0 && r <= 0xFF {
+		return byte(r), true
+	}
+	return 0, false
+}
+
+// fixDoubleEncoded undoes UTF-8 that was encoded to UTF-8 again as if it were
+// latin1, so Ã© becomes é again. Only characters whose latin1 bytes form a
+// valid UTF-8 character are turned back, everything else is kept as it is.
+func fixDoubleEncoded(text []byte) []byte {
+	fixed := make([]byte, 0, len(text))
+	for i := 0; i < len(text); {
+		if text[i] < utf8.RuneSelf {
+			fixed = append(fixed, text[i])
+			i++
+			continue
+		}
+
+		if character, n, ok := doubleEncodedRune(text[i:]); ok {
+			fixed = append(fixed, character...)
+			i += n
+			continue
+		}
+
+		_, size := utf8.DecodeRune(text[i:])
+		fixed = append(fixed, text[i:i+size]...)
+		i += size
+	}
+	return fixed
+}
+
+// doubleEncodedRune reads one double encoded character at the start of text,
+// and returns it as UTF-8 with the number of bytes it took up in text
+func doubleEncodedRune(text []byte) ([]byte, int, bool) {
+	var character []byte
+	n := 0
+	for len(character) < utf8.UTFMax && n < len(text) {
+		// an invalid byte decodes to utf8.RuneError, which isn't latin1
+		r, size := utf8.DecodeRune(text[n:])
+		c, ok := latin1Byte(r)
+		if !ok {
+			return nil, 0, false
+		}
+
+		character = append(character, c)
+		n += size
+
+		if utf8.FullRune(character) {
+			if r, size := utf8.DecodeRune(character); r == utf8.RuneError && size == 1 {
+				return nil, 0, false
+			}
+			return character, n, true
+		}
+	}
+	return nil, 0, false
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLatin1ToUTF8(t *testing.T) {
+	var tests = []struct {
+		testName string
+		in       string
+		out      string
+	}{
+		{
+			testName: "ASCII",
+			in:       "https://uss-enterprise.com",
+			out:      "https://uss-enterprise.com",
+		},
+		{
+			testName: "latin1 letters",
+			in:       "na\xefve caf\xe9 \xa9",
+			out:      "naïve café ©",
+		},
+		{
+			testName: "cp1252 characters",
+			in:       "\x80 \x93quoted\x94 \x85",
+			out:      "€ “quoted” …",
+		},
+		{
+			testName: "undefined cp1252 bytes",
+			in:       "\x81\x9d",
+			out:      "\u0081\u009d",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			if actual := latin1ToUTF8([]byte(test.in)); string(actual) != test.out {
+				t.Error("Expected:", test.out, "Actual:", string(actual))
+			}
+		})
+	}
+}
+
+func TestFixDoubleEncoded(t *testing.T) {
+	var tests = []struct {
+		testName string
+		in       string
+		out      string
+	}{
+		{
+			testName: "double encoded",
+			in:       "CafÃ© naÃ¯ve Ã¤Ã¶Ã¼",
+			out:      "Café naïve äöü",
+		},
+		{
+			testName: "double encoded through cp1252",
+			in:       "â‚¬ â€œquotedâ€\u009d æ—¥æœ¬ ðŸš€",
+			out:      "€ “quoted” 日本 🚀",
+		},
+		{
+			testName: "UTF-8 encoded once",
+			in:       "Café 日本 🚀 Ã alone",
+			out:      "Café 日本 🚀 Ã alone",
+		},
+		{
+			testName: "invalid UTF-8",
+			in:       "Caf\xe9 Ã\xa9",
+			out:      "Caf\xe9 Ã\xa9",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			if actual := fixDoubleEncoded([]byte(test.in)); string(actual) != test.out {
+				t.Error("Expected:", test.out, "Actual:", string(actual))
+			}
+		})
+	}
+}
+
+func TestCharsetReplace(t *testing.T) {
+	defer func() { convertCharset = nil }()
+
+	replacements := []*Replacement{
+		{
+			From: []byte("http://uss-enterprise.com"),
+			To:   []byte("https://ncc-1701-d.space"),
+		},
+	}
+
+	var tests = []struct {
+		testName string
+		charset  string
+		in       string
+		out      string
+	}{
+		{
+			testName: "latin1",
+			charset:  charsetLatin1,
+			in:       "('a:1:{i:0;s:7:\\\"na\xefve \xa9\\\";}','Caf\xe9 http://uss-enterprise.com')",
+			out:      `('a:1:{i:0;s:9:\"naïve ©\";}','Café https://ncc-1701-d.space')`,
+		},
+		{
+			testName: "latin1 with replacing",
+			charset:  charsetLatin1,
+			in:       "('s:30:\\\"Caf\xe9 http://uss-enterprise.com\\\";')",
+			out:      `('s:30:\"Café https://ncc-1701-d.space\";')`,
+		},
+		{
+			testName: "latin1 nested",
+			charset:  charsetLatin1,
+			in:       "('s:21:\\\"a:1:{i:0;s:4:\\\"Caf\xe9\\\";}\\\";')",
+			out:      `('s:22:\"a:1:{i:0;s:5:\"Café\";}\";')`,
+		},
+		{
+			testName: "double encoded",
+			charset:  charsetDoubleEncoded,
+			in:       `('a:1:{s:4:\"name\";s:7:\"CafÃ©\";}','Ã¤Ã¶Ã¼')`,
+			out:      `('a:1:{s:4:\"name\";s:5:\"Café\";}','äöü')`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			convertCharset = charsetConversions[test.charset]
+
+			in := []byte(test.in)
+			replaced := fixLine(&in, replacements)
+
+			if !bytes.Equal(*replaced, []byte(test.out)) {
+				t.Error("Expected:", test.out, "Actual:", string(*replaced))
+			}
+		})
+	}
+}
+
+func TestCharsetJSONReplace(t *testing.T) {
+	convertCharset = latin1ToUTF8
+	jsonAware = true
+	defer func() { convertCharset, jsonAware = nil, false }()
+
+	replacements := []*Replacement{
+		{
+			From: []byte("http://uss-enterprise.com"),
+			To:   []byte("https://ncc-1701-d.space"),
+		},
+	}
+
+	var tests = []struct {
+		testName string
+		in       string
+		out      string
+	}{
+		{
+			testName: "unicode escape",
+			in:       `('{\"k\":\"caf\\u00e9\"}')`,
+			out:      `('{\"k\":\"caf\\u00e9\"}')`,
+		},
+		{
+			testName: "unicode escape and latin1 with replacing",
+			in:       "('{\\\"k\\\":\\\"caf\\\\u00e9 \xe9 http://uss-enterprise.com\\\"}')",
+			out:      `('{\"k\":\"caf\\u00e9 \\u00e9 https://ncc-1701-d.space\"}')`,
+		},
+		{
+			testName: "latin1",
+			in:       "('{\\\"caf\xe9\\\":\\\"caf\xe9 http://uss-enterprise.com\\\"}')",
+			out:      `('{\"café\":\"café https://ncc-1701-d.space\"}')`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			in := []byte(test.in)
+			replaced := fixLine(&in, replacements)
+
+			if !bytes.Equal(*replaced, []byte(test.out)) {
+				t.Error("Expected:", test.out, "Actual:", string(*replaced))
+			}
+		})
+	}
+}
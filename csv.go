@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+)
+
+const (
+	formatSQL = "sql"
+	formatCSV = "csv"
+	formatTSV = "tsv"
+)
+
+// csvFormat describes a file of delimited records
+type csvFormat struct {
+	delimiter byte
+
+	// enclosure quotes fields and is doubled inside them, 0 if fields are
+	// never enclosed
+	enclosure byte
+
+	// esc is the escaping of field values once the enclosure is removed
+	esc *escaping
+}
+
+// newCSVFormat returns the settings of a format: csv follows RFC 4180 as
+// written by spreadsheets, tsv is the default output of SELECT ... INTO
+// OUTFILE, with backslash escapes and no enclosure. A non-zero delimiter
+// replaces the default one.
+func newCSVFormat(format string, delimiter byte) *csvFormat {
+	f := &csvFormat{
+		delimiter: ',',
+		enclosure: '"',
+		esc:       noneEscaping,
+	}
+	if format == formatTSV {
+		f = &csvFormat{
+			delimiter: '\t',
+			esc:       outfileEscaping,
+		}
+	}
+
+	if delimiter != 0 {
+		f.delimiter = delimiter
+	}
+
+	return f
+}
+
+// csvReader reads whole records, which can span several lines when a field
+// contains a line break
+type csvReader struct {
+	r      *bufio.Reader
+	format *csvFormat
+}
+
+func (c *csvReader) next() ([]byte, *escaping, error) {
+	record, err := c.r.ReadBytes('\n')
+	for err == nil && c.format.continues(record) {
+		var more []byte
+		more, err = c.r.ReadBytes('\n')
+		record = append(record, more...)
+	}
+	return record, c.format.esc, err
+}
+
+// continues tells whether the line break at the end of record is part of a
+// field rather than the end of the record
+func (f *csvFormat) continues(record []byte) bool {
+	if f.enclosure != 0 {
+		return bytes.Count(record, []byte{f.enclosure})%2 == 1
+	}
+
+	backslashes := 0
+	for i := len(record) - 2; i >= 0 && record[i] == '\\'; i-- {
+		backslashes++
+	}
+	return backslashes%2 == 1
+}
+
+// fixRecord applies the replacements to every field of record and fixes the
+// serialized data inside them. Delimiters, enclosures and the line ending are
+// written back as they were.
+func (f *csvFormat) fixRecord(record *[]byte, replacements []*Replacement, esc *escaping) *[]byte {
+	body := *record
+	var ending []byte
+	if bytes.HasSuffix(body, []byte("\r\n")) {
+		body, ending = body[:len(body)-2], body[len(body)-2:]
+	} else if bytes.HasSuffix(body, []byte("\n")) {
+		body, ending = body[:len(body)-1], body[len(body)-1:]
+	}
+
+	var rebuilt []byte
+	for i, field := range f.split(body) {
+		if i > 0 {
+			rebuilt = append(rebuilt, f.delimiter)
+		}
+		rebuilt = append(rebuilt, f.fixField(field, replacements, esc)...)
+	}
+	rebuilt = append(rebuilt, ending...)
+
+	return &rebuilt
+}
+
+// values returns the unescaped values of the fields of a record
+func (f *csvFormat) values(record []byte, esc *escaping) [][]byte {
+	var values [][]byte
+	for _, field := range f.split(bytes.TrimRight(record, "\r\n")) {
+		if f.enclosure != 0 && len(field) >= 2 && field[0] == f.enclosure && field[len(field)-1] == f.enclosure {
+			field = bytes.ReplaceAll(field[1:len(field)-1], []byte{f.enclosure, f.enclosure}, []byte{f.enclosure})
+		}
+		values = append(values, esc.unescape(field))
+	}
+	return values
+}
+
+// split returns the fields of a record, enclosures included
+func (f *csvFormat) split(body []byte) [][]byte {
+	var fields [][]byte
+	start := 0
+	enclosed := false
+	for i := 0; i < len(body); i++ {
+		switch c := body[i]; {
+		case f.enclosure != 0 && c == f.enclosure:
+			enclosed = !enclosed
+		case f.enclosure == 0 && c == '\\':
+			i++
+		case c == f.delimiter && !enclosed:
+			fields = append(fields, body[start:i])
+			start = i + 1
+		}
+	}
+	return append(fields, body[start:])
+}
+
+func (f *csvFormat) fixField(field []byte, replacements []*Replacement, esc *escaping) []byte {
+	enclosure := []byte{f.enclosure}
+	doubled := []byte{f.enclosure, f.enclosure}
+
+	enclosed := f.enclosure != 0 && len(field) >= 2 && field[0] == f.enclosure && field[len(field)-1] == f.enclosure
+	if enclosed {
+		field = bytes.ReplaceAll(field[1:len(field)-1], doubled, enclosure)
+	}
+
+	value := append([]byte{}, field...)
+	fixed := *fixLineWithEscaping(&value, replacements, esc)
+
+	if f.enclosure == 0 {
+		return fixed
+	}
+
+	// a value that now needs an enclosure gets one, others keep their style
+	if !enclosed && !bytes.ContainsAny(fixed, string([]byte{f.delimiter, f.enclosure, '\n', '\r'})) {
+		return fixed
+	}
+
+	quoted := append([]byte{f.enclosure}, bytes.ReplaceAll(fixed, enclosure, doubled)...)
+	return append(quoted, f.enclosure)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCSVReader(t *testing.T) {
+	var tests = []struct {
+		testName string
+		format   string
+		in       string
+		records  []string
+	}{
+		{
+			testName: "csv field with a line break",
+			format:   formatCSV,
+			in:       "1,\"first\nsecond\"\n2,\"say \"\"hi\"\"\"\n",
+			records:  []string{"1,\"first\nsecond\"\n", "2,\"say \"\"hi\"\"\"\n"},
+		},
+		{
+			testName: "tsv escaped line break",
+			format:   formatTSV,
+			in:       "1\tfirst\\\nsecond\n2\tC:\\\\\n3\tlast",
+			records:  []string{"1\tfirst\\\nsecond\n", "2\tC:\\\\\n", "3\tlast"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			r := &csvReader{
+				r:      bufio.NewReader(strings.NewReader(test.in)),
+				format: newCSVFormat(test.format, 0),
+			}
+
+			var records []string
+			for {
+				record, _, err := r.next()
+				if len(record) > 0 {
+					records = append(records, string(record))
+				}
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if strings.Join(records, "|") != strings.Join(test.records, "|") {
+				t.Error("Expected:", test.records, "Actual:", records)
+			}
+		})
+	}
+}
+
+func TestFixCSVRecord(t *testing.T) {
+	var tests = []struct {
+		testName  string
+		format    string
+		delimiter byte
+		to        string
+		in        string
+		out       string
+	}{
+		{
+			testName: "enclosed serialized field",
+			format:   formatCSV,
+			in:       "1,https://example.com,\"a:1:{s:3:\"\"url\"\";s:19:\"\"https://example.com\"\";}\"\r\n",
+			out:      "1,https://example.org/new,\"a:1:{s:3:\"\"url\"\";s:23:\"\"https://example.org/new\"\";}\"\r\n",
+		},
+		{
+			testName: "field with a line break",
+			format:   formatCSV,
+			in:       "2,\"a:1:{s:4:\"\"text\"\";s:24:\"\"line\nhttps://example.com\"\";}\"\n",
+			out:      "2,\"a:1:{s:4:\"\"text\"\";s:28:\"\"line\nhttps://example.org/new\"\";}\"\n",
+		},
+		{
+			testName:  "other delimiter",
+			format:    formatCSV,
+			delimiter: ';',
+			in:        "1;\"https://example.com\";https://example.com, too\n",
+			out:       "1;\"https://example.org/new\";https://example.org/new, too\n",
+		},
+		{
+			testName: "field that needs an enclosure after replacing",
+			format:   formatCSV,
+			to:       "https://example.org/a,b",
+			in:       "1,https://example.com\n",
+			out:      "1,\"https://example.org/a,b\"\n",
+		},
+		{
+			testName: "tsv with escapes",
+			format:   formatTSV,
+			in:       "1\ta:2:{i:0;s:24:\"line\\\nhttps://example.com\";i:1;s:6:\"C:\\\\new\";}\t\\N\n",
+			out:      "1\ta:2:{i:0;s:28:\"line\\\nhttps://example.org/new\";i:1;s:6:\"C:\\\\new\";}\t\\N\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			to := test.to
+			if to == "" {
+				to = "https://example.org/new"
+			}
+
+			format := newCSVFormat(test.format, test.delimiter)
+			in := []byte(test.in)
+			replaced := format.fixRecord(&in, []*Replacement{
+				{
+					From: []byte("https://example.com"),
+					To:   []byte(to),
+				},
+			}, format.esc)
+
+			if !bytes.Equal(*replaced, []byte(test.out)) {
+				t.Error("Expected:", test.out, "Actual:", string(*replaced))
+			}
+		})
+	}
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strconv"
+)
+
+const (
+	dialectAuto     = "auto"
+	dialectMySQL    = "mysql"
+	dialectPostgres = "postgres"
+	dialectSQLite   = "sqlite"
+)
+
+var dialects = map[string]bool{
+	dialectAuto:     true,
+	dialectMySQL:    true,
+	dialectPostgres: true,
+	dialectSQLite:   true,
+}
+
+// headerSize is how much of the input is looked at to detect the dialect
+const headerSize = 4096
+
+// copyStartRe matches the statement pg_dump writes in front of table data
+var copyStartRe = regexp.MustCompile(`(?i)^COPY\s.*\sFROM\s+stdin;\s*$`)
+
+var copyEnd = []byte(`\.`)
+
+// sqliteLineBreakRe matches the replace(...) wrapper sqlite3 .dump puts around
+// strings containing line breaks, e.g. replace('a\nb','\n',char(10)), after
+// the string. The marker is chosen so it doesn't occur in that string, so it
+// isn't always \n, and other strings of the statement can contain it.
+var sqliteLineBreakRe = regexp.MustCompile(`^,'([^']+)',char\((10|13)\)\)`)
+
+// unitReader splits the input into the units that are fixed one at a time,
+// and tells which escaping applies to each of them
+type unitReader interface {
+	next() ([]byte, *escaping, error)
+}
+
+// dumpReader splits a dump into the units fixLine works on and tells which
+// escaping applies to each of them.
+//
+// MySQL dumps escape line breaks, so every line is a unit. pg_dump writes table
+// data in COPY blocks, one row per line with backslash escapes, and everything
+// else (including INSERT statements written with --inserts) as standard SQL,
+// where a string can contain raw line breaks. Lines of such statements are
+// joined until their strings, dollar quoted bodies and comments are closed.
+// sqlite3 .dump writes standard SQL as well.
+type dumpReader struct {
+	r       *bufio.Reader
+	dialect string
+	inCopy  bool
+
+	// pending is a line that was read ahead and ended a statement, with the
+	// error that came with it
+	pending    []byte
+	pendingErr error
+}
+
+// sqlState is where a scan of standard SQL is: in a string, a dollar quoted
+// body or a block comment, or none of them
+type sqlState struct {
+	quoted bool
+
+	// dollarTag closes the dollar quoted body the scan is in, e.g. $body$
+	dollarTag []byte
+
+	// comments is how deep the scan is in nested /* */ comments
+	comments int
+}
+
+// dollarTagRe matches the tag that opens a dollar quoted string in PostgreSQL
+var dollarTagRe = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z_0-9]*)?\$`)
+
+// newDumpReader returns a dumpReader for the given dialect. With dialectAuto
+// the dialect is detected from the start of the input.
+func newDumpReader(r *bufio.Reader, dialect string) *dumpReader {
+	if dialect == dialectAuto {
+		// Peek returns what it could read along with the error at the end of
+		// short input, which is all detectDialect needs
+		header, _ := r.Peek(headerSize)
+		dialect = detectDialect(header)
+	}
+
+	return &dumpReader{
+		r:       r,
+		dialect: dialect,
+	}
+}
+
+// next returns the next unit and its escaping. Like bufio.Reader.ReadBytes, it
+// returns the data read before an error.
+func (d *dumpReader) next() ([]byte, *escaping, error) {
+	line, err := d.readLine()
+	switch d.dialect {
+	case dialectMySQL:
+		return line, mysqlEscaping, err
+	case dialectSQLite:
+		line, err = d.readStatement(line, err)
+		return line, standardEscaping, err
+	}
+
+	trimmed := bytes.TrimRight(line, "\r\n")
+
+	if d.inCopy {
+		if bytes.Equal(trimmed, copyEnd) {
+			d.inCopy = false
+			return line, standardEscaping, err
+		}
+		return line, copyEscaping, err
+	}
+
+	if copyStartRe.Match(trimmed) {
+		d.inCopy = true
+		return line, standardEscaping, err
+	}
+
+	line, err = d.readStatement(line, err)
+	return line, standardEscaping, err
+}
+
+// readLine returns the line read ahead, if any, or the next line
+func (d *dumpReader) readLine() ([]byte, error) {
+	if d.pending != nil {
+		line, err := d.pending, d.pendingErr
+		d.pending, d.pendingErr = nil, nil
+		return line, err
+	}
+	return d.r.ReadBytes('\n')
+}
+
+// readStatement appends lines to line until its strings, dollar quoted bodies
+// and comments are closed. The start of a COPY block ends the statement in any
+// case, so one stray quote can't take the table data along with it.
+func (d *dumpReader) readStatement(line []byte, err error) ([]byte, error) {
+	var state sqlState
+	state.scan(line, d.dialect == dialectPostgres)
+
+	for err == nil && state.open() {
+		var more []byte
+		more, err = d.r.ReadBytes('\n')
+
+		if d.dialect == dialectPostgres && copyStartRe.Match(bytes.TrimRight(more, "\r\n")) {
+			d.pending, d.pendingErr = more, err
+			return line, nil
+		}
+
+		state.scan(more, d.dialect == dialectPostgres)
+		line = append(line, more...)
+	}
+
+	return line, err
+}
+
+// open tells whether the scan ended inside a string, body or comment
+func (s *sqlState) open() bool {
+	return s.quoted || s.dollarTag != nil || s.comments > 0
+}
+
+// scan moves the state over a line of standard SQL. Dollar quoting is
+// PostgreSQL only.
+func (s *sqlState) scan(line []byte, dollarQuotes bool) {
+	for i := 0; i < len(line); i++ {
+		switch {
+		case s.comments > 0:
+			if bytes.HasPrefix(line[i:], []byte("*/")) {
+				s.comments--
+				i++
+			} else if bytes.HasPrefix(line[i:], []byte("/*")) {
+				s.comments++
+				i++
+			}
+		case s.quoted:
+			// a doubled quote closes the string and opens it again
+			s.quoted = line[i] != '\''
+		case s.dollarTag != nil:
+			if bytes.HasPrefix(line[i:], s.dollarTag) {
+				i += len(s.dollarTag) - 1
+				s.dollarTag = nil
+			}
+		case line[i] == '\'':
+			s.quoted = true
+		case bytes.HasPrefix(line[i:], []byte("--")):
+			return
+		case bytes.HasPrefix(line[i:], []byte("/*")):
+			s.comments++
+			i++
+		case dollarQuotes && line[i] == '$' && (i == 0 || !isWordByte(line[i-1])):
+			if tag := dollarTagRe.Find(line[i:]); tag != nil {
+				s.dollarTag = tag
+				i += len(tag) - 1
+			}
+		}
+	}
+}
+
+// detectDialect guesses the dialect of a dump from its first bytes
+func detectDialect(header []byte) string {
+	switch {
+	case bytes.Contains(header, []byte("-- PostgreSQL database dump")):
+		return dialectPostgres
+	case bytes.HasPrefix(header, []byte("PRAGMA foreign_keys=OFF;")),
+		bytes.HasPrefix(header, []byte("BEGIN TRANSACTION;")):
+		return dialectSQLite
+	}
+	return dialectMySQL
+}
+
+// sqliteParts splits a statement from sqlite3 .dump into parts with the
+// escaping of each. That is standard SQL, except that line breaks in a string
+// may be written as a marker that a replace() call around it turns back into a
+// line break, so the contents of such strings are parts of their own.
+func sqliteParts(statement []byte) ([][]byte, []*escaping) {
+	var parts [][]byte
+	var escs []*escaping
+
+	// open is where the string the scan is in starts, -1 outside of strings
+	last, open := 0, -1
+	for i := 0; i < len(statement); i++ {
+		switch {
+		case statement[i] != '\'':
+		case open < 0:
+			open = i
+		case i+1 < len(statement) && statement[i+1] == '\'':
+			i++
+		default:
+			if markers := sqliteMarkers(statement[i+1:]); markers != nil {
+				parts = append(parts, statement[last:open+1], statement[open+1:i])
+				escs = append(escs, standardEscaping, sqliteEscaping(markers))
+				last = i
+			}
+			open = -1
+		}
+	}
+
+	return append(parts, statement[last:]), append(escs, standardEscaping)
+}
+
+// sqliteMarkers returns the line break markers declared by the replace()
+// wrappers at the start of rest, which follows a string, or nil if there are
+// none
+func sqliteMarkers(rest []byte) map[byte][]byte {
+	var markers map[byte][]byte
+	for {
+		match := sqliteLineBreakRe.FindSubmatchIndex(rest)
+		if match == nil {
+			return markers
+		}
+		if markers == nil {
+			markers = make(map[byte][]byte)
+		}
+
+		c := byte('\r')
+		if string(rest[match[4]:match[5]]) == "10" {
+			c = '\n'
+		}
+		markers[c] = rest[match[2]:match[3]]
+		rest = rest[match[1]:]
+	}
+}
+
+// sqliteEscaping returns the escaping of a string wrapped in replace() calls
+// that turn the markers back into the line breaks they stand for
+func sqliteEscaping(markers map[byte][]byte) *escaping {
+	esc := *standardEscaping
+	esc.name = dialectSQLite
+	esc.decode = func(b []byte) (int, []byte) {
+		for c, marker := range markers {
+			if bytes.HasPrefix(b, marker) {
+				return len(marker), []byte{c}
+			}
+		}
+		return decodeStandard(b)
+	}
+	esc.escape = func(raw []byte) []byte {
+		escaped := make([]byte, 0, len(raw)+len(raw)/8)
+		for _, c := range standardEscape(raw) {
+			if marker, ok := markers[c]; ok {
+				escaped = append(escaped, marker...)
+				continue
+			}
+			escaped = append(escaped, c)
+		}
+		return escaped
+	}
+
+	return &esc
+}
+
+// fixSQLiteStatement fixes every part of a statement from sqlite3 .dump with
+// its own escaping
+func fixSQLiteStatement(statement *[]byte, replacements []*Replacement, _ *escaping) *[]byte {
+	parts, escs := sqliteParts(*statement)
+	if len(parts) == 1 {
+		return fixLineWithEscaping(statement, replacements, standardEscaping)
+	}
+
+	var rebuilt []byte
+	for i, part := range parts {
+		part = append([]byte{}, part...)
+		rebuilt = append(rebuilt, *fixLineWithEscaping(&part, replacements, escs[i])...)
+	}
+	return &rebuilt
+}
+
+// sqliteValues unescapes every part of a statement from sqlite3 .dump
+func sqliteValues(statement []byte, _ *escaping) [][]byte {
+	parts, escs := sqliteParts(statement)
+
+	var values [][]byte
+	for i, part := range parts {
+		values = append(values, escs[i].unescape(part))
+	}
+	return values
+}
+
+// escapingReader returns units read by r with esc as their escaping
+type escapingReader struct {
+	r   unitReader
+	esc *escaping
+}
+
+func (e *escapingReader) next() ([]byte, *escaping, error) {
+	unit, _, err := e.r.next()
+	return unit, e.esc, err
+}
+
+// rawReader reads unescaped text, such as the output of
+// wp option get --format=serialize. Serialized strings can contain raw line
+// breaks there, so lines are joined until no string is cut off.
+type rawReader struct {
+	r *bufio.Reader
+}
+
+func (raw *rawReader) next() ([]byte, *escaping, error) {
+	line, err := raw.r.ReadBytes('\n')
+	for err == nil && pendingSerializedString(line) {
+		var more []byte
+		more, err = raw.r.ReadBytes('\n')
+		line = append(line, more...)
+	}
+	return line, noneEscaping, err
+}
+
+// pendingSerializedString tells whether the unescaped unit ends inside a
+// serialized string
+func pendingSerializedString(unit []byte) bool {
+	for offset := 0; offset < len(unit); {
+		match := noneEscaping.stringPrefix.FindSubmatchIndex(unit[offset:])
+		if match == nil {
+			return false
+		}
+
+		size, err := strconv.Atoi(string(unit[offset+match[2] : offset+match[3]]))
+		if err != nil {
+			return false
+		}
+
+		// the content and the closing ";
+		offset += match[1] + size + 2
+		if offset > len(unit) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDumpReader(t *testing.T) {
+	dump := "-- PostgreSQL database dump\n" +
+		"-- Don't edit\n" +
+		"COPY public.wp_options (option_id, option_value) FROM stdin;\n" +
+		"1\tit's here\n" +
+		"\\.\n" +
+		"INSERT INTO public.wp_posts VALUES (1, 'first\n" +
+		"second');\n" +
+		"SELECT 1;"
+
+	var tests = []struct {
+		dialect  string
+		units    []string
+		escaping []string
+	}{
+		{
+			dialect: dialectPostgres,
+			units: []string{
+				"-- PostgreSQL database dump\n",
+				"-- Don't edit\n",
+				"COPY public.wp_options (option_id, option_value) FROM stdin;\n",
+				"1\tit's here\n",
+				"\\.\n",
+				"INSERT INTO public.wp_posts VALUES (1, 'first\nsecond');\n",
+				"SELECT 1;",
+			},
+			escaping: []string{"standard", "standard", "standard", "postgres", "standard", "standard", "standard"},
+		},
+		{
+			dialect: dialectMySQL,
+			units: []string{
+				"-- PostgreSQL database dump\n",
+				"-- Don't edit\n",
+				"COPY public.wp_options (option_id, option_value) FROM stdin;\n",
+				"1\tit's here\n",
+				"\\.\n",
+				"INSERT INTO public.wp_posts VALUES (1, 'first\n",
+				"second');\n",
+				"SELECT 1;",
+			},
+			escaping: []string{"mysql", "mysql", "mysql", "mysql", "mysql", "mysql", "mysql", "mysql"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.dialect, func(t *testing.T) {
+			r := newDumpReader(bufio.NewReader(strings.NewReader(dump)), test.dialect)
+
+			var units, escaping []string
+			for {
+				unit, esc, err := r.next()
+				if len(unit) > 0 {
+					units = append(units, string(unit))
+					escaping = append(escaping, esc.name)
+				}
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if strings.Join(units, "|") != strings.Join(test.units, "|") {
+				t.Error("Expected:", test.units, "Actual:", units)
+			}
+			if strings.Join(escaping, ",") != strings.Join(test.escaping, ",") {
+				t.Error("Expected:", test.escaping, "Actual:", escaping)
+			}
+		})
+	}
+}
+
+func TestDumpReaderQuotesInBodiesAndComments(t *testing.T) {
+	dump := "-- PostgreSQL database dump\n" +
+		"CREATE FUNCTION public.greet() RETURNS text\n" +
+		"    LANGUAGE plpgsql\n" +
+		"    AS $$\n" +
+		"BEGIN\n" +
+		"  -- don't return NULL\n" +
+		"  RETURN 'it''s ' || $q$don't$q$;\n" +
+		"END;\n" +
+		"$$;\n" +
+		"/* the site's options\n" +
+		"   follow */\n" +
+		"SELECT 'a', -- isn't it\n" +
+		"  'b';\n" +
+		"INSERT INTO public.wp_options VALUES ('unclosed\n" +
+		"COPY public.wp_options (option_value) FROM stdin;\n" +
+		"s:25:\"http://uss-enterprise.com\";\n" +
+		"\\.\n"
+
+	expected := []string{
+		"-- PostgreSQL database dump\n",
+		"CREATE FUNCTION public.greet() RETURNS text\n",
+		"    LANGUAGE plpgsql\n",
+		"    AS $$\nBEGIN\n  -- don't return NULL\n  RETURN 'it''s ' || $q$don't$q$;\nEND;\n$$;\n",
+		"/* the site's options\n   follow */\n",
+		"SELECT 'a', -- isn't it\n",
+		"  'b';\n",
+		"INSERT INTO public.wp_options VALUES ('unclosed\n",
+		"COPY public.wp_options (option_value) FROM stdin;\n",
+		"s:25:\"http://uss-enterprise.com\";\n",
+		"\\.\n",
+	}
+	expectedEscaping := []string{"standard", "standard", "standard", "standard", "standard", "standard", "standard", "standard", "standard", "postgres", "standard"}
+
+	r := newDumpReader(bufio.NewReader(strings.NewReader(dump)), dialectAuto)
+
+	var units, escaping []string
+	for {
+		unit, esc, err := r.next()
+		if len(unit) > 0 {
+			units = append(units, string(unit))
+			escaping = append(escaping, esc.name)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if strings.Join(units, "|") != strings.Join(expected, "|") {
+		t.Error("Expected:", expected, "Actual:", units)
+	}
+	if strings.Join(escaping, ",") != strings.Join(expectedEscaping, ",") {
+		t.Error("Expected:", expectedEscaping, "Actual:", escaping)
+	}
+}
+
+func TestPostgresReplace(t *testing.T) {
+	var tests = []struct {
+		testName string
+		esc      *escaping
+		in       string
+		out      string
+	}{
+		{
+			testName: "COPY row",
+			esc:      copyEscaping,
+			in:       "1\ta:2:{s:3:\"url\";s:19:\"https://example.com\";s:4:\"note\";s:5:\"a\\\\b\\nc\";}\n",
+			out:      "1\ta:2:{s:3:\"url\";s:23:\"https://example.org/new\";s:4:\"note\";s:5:\"a\\\\b\\nc\";}\n",
+		},
+		{
+			testName: "COPY row with octal and hex escapes",
+			esc:      copyEscaping,
+			in:       "1\ta:1:{i:0;s:21:\"\\101\\x42https://example.com\";}\n",
+			out:      "1\ta:1:{i:0;s:25:\"\\101\\x42https://example.org/new\";}\n",
+		},
+		{
+			testName: "standard string with a doubled quote",
+			esc:      standardEscaping,
+			in:       `INSERT INTO wp_options VALUES (1, 'a:1:{s:3:"url";s:24:"it''s https://example.com";}');`,
+			out:      `INSERT INTO wp_options VALUES (1, 'a:1:{s:3:"url";s:28:"it''s https://example.org/new";}');`,
+		},
+		{
+			testName: "standard string keeps backslashes",
+			esc:      standardEscaping,
+			in:       `INSERT INTO wp_options VALUES (1, 'a:1:{s:3:"url";s:21:"\\https://example.com";}');`,
+			out:      `INSERT INTO wp_options VALUES (1, 'a:1:{s:3:"url";s:25:"\\https://example.org/new";}');`,
+		},
+		{
+			testName: "standard string with a line break",
+			esc:      standardEscaping,
+			in:       "INSERT INTO wp_posts VALUES (1, 'a:1:{s:4:\"text\";s:24:\"line\nhttps://example.com\";}');\n",
+			out:      "INSERT INTO wp_posts VALUES (1, 'a:1:{s:4:\"text\";s:28:\"line\nhttps://example.org/new\";}');\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			in := []byte(test.in)
+			replaced := fixLineWithEscaping(&in, []*Replacement{
+				{
+					From: []byte("https://example.com"),
+					To:   []byte("https://example.org/new"),
+				},
+			}, test.esc)
+
+			if !bytes.Equal(*replaced, []byte(test.out)) {
+				t.Error("Expected:", test.out, "Actual:", string(*replaced))
+			}
+		})
+	}
+}
+
+func TestDetectDialect(t *testing.T) {
+	var tests = []struct {
+		testName string
+		header   string
+		dialect  string
+	}{
+		{
+			testName: "pg_dump",
+			header:   "--\n-- PostgreSQL database dump\n--\n\nSET statement_timeout = 0;\n",
+			dialect:  dialectPostgres,
+		},
+		{
+			testName: "sqlite3 .dump",
+			header:   "PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\nCREATE TABLE wp_options (option_id integer);\n",
+			dialect:  dialectSQLite,
+		},
+		{
+			testName: "sqlite3 .dump without foreign keys",
+			header:   "BEGIN TRANSACTION;\nCREATE TABLE wp_options (option_id integer);\n",
+			dialect:  dialectSQLite,
+		},
+		{
+			testName: "mysqldump",
+			header:   "-- MySQL dump 10.13  Distrib 8.0.36, for Linux (x86_64)\n",
+			dialect:  dialectMySQL,
+		},
+		{
+			testName: "anything else",
+			header:   "INSERT INTO wp_options VALUES (1,'https://example.com');\n",
+			dialect:  dialectMySQL,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			if dialect := detectDialect([]byte(test.header)); dialect != test.dialect {
+				t.Error("Expected:", test.dialect, "Actual:", dialect)
+			}
+		})
+	}
+}
+
+func TestSQLiteReplace(t *testing.T) {
+	var tests = []struct {
+		testName string
+		in       string
+		out      string
+	}{
+		{
+			testName: "doubled quote",
+			in:       `INSERT INTO wp_options VALUES(1,'a:1:{s:3:"url";s:24:"it''s https://example.com";}');`,
+			out:      `INSERT INTO wp_options VALUES(1,'a:1:{s:3:"url";s:28:"it''s https://example.org/new";}');`,
+		},
+		{
+			testName: "line break marker",
+			in:       `INSERT INTO wp_posts VALUES(1,replace('a:1:{s:4:"text";s:24:"line\nhttps://example.com";}','\n',char(10)));`,
+			out:      `INSERT INTO wp_posts VALUES(1,replace('a:1:{s:4:"text";s:28:"line\nhttps://example.org/new";}','\n',char(10)));`,
+		},
+		{
+			testName: "line break marker when the string contains \\n",
+			in:       `INSERT INTO wp_posts VALUES(1,replace(replace('a:1:{s:4:"text";s:29:"C:\new\012\r\nhttps://example.com";}','\012',char(10)),'\r',char(13)));`,
+			out:      `INSERT INTO wp_posts VALUES(1,replace(replace('a:1:{s:4:"text";s:33:"C:\new\012\r\nhttps://example.org/new";}','\012',char(10)),'\r',char(13)));`,
+		},
+		{
+			testName: "line break marker in another column",
+			in:       `INSERT INTO wp_posts VALUES(1,replace('s:24:"line\nhttps://example.com";','\n',char(10)),'s:26:"C:\new\https://example.com";');`,
+			out:      `INSERT INTO wp_posts VALUES(1,replace('s:28:"line\nhttps://example.org/new";','\n',char(10)),'s:30:"C:\new\https://example.org/new";');`,
+		},
+		{
+			testName: "doubled quote before a line break marker",
+			in:       `INSERT INTO wp_posts VALUES('it''s',replace('s:24:"line\nhttps://example.com";','\n',char(10)));`,
+			out:      `INSERT INTO wp_posts VALUES('it''s',replace('s:28:"line\nhttps://example.org/new";','\n',char(10)));`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			in := []byte(test.in)
+			replaced := fixSQLiteStatement(&in, []*Replacement{
+				{
+					From: []byte("https://example.com"),
+					To:   []byte("https://example.org/new"),
+				},
+			}, standardEscaping)
+
+			if !bytes.Equal(*replaced, []byte(test.out)) {
+				t.Error("Expected:", test.out, "Actual:", string(*replaced))
+			}
+		})
+	}
+}
+
+func TestRawReader(t *testing.T) {
+	in := "a:2:{s:4:\"text\";s:24:\"line\nhttps://example.com\";s:4:\"path\";s:6:\"C:\\new\";}\n" +
+		"s:3:\"one\";\n"
+
+	units := []string{
+		"a:2:{s:4:\"text\";s:24:\"line\nhttps://example.com\";s:4:\"path\";s:6:\"C:\\new\";}\n",
+		"s:3:\"one\";\n",
+	}
+
+	r := &rawReader{r: bufio.NewReader(strings.NewReader(in))}
+
+	var actual []string
+	for {
+		unit, esc, err := r.next()
+		if len(unit) > 0 {
+			actual = append(actual, string(unit))
+			if esc != noneEscaping {
+				t.Error("Expected: none Actual:", esc.name)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if strings.Join(actual, "|") != strings.Join(units, "|") {
+		t.Error("Expected:", units, "Actual:", actual)
+	}
+}
+
+func TestNoneEscapingReplace(t *testing.T) {
+	in := []byte("a:2:{s:4:\"text\";s:24:\"line\nhttps://example.com\";s:4:\"path\";s:23:\"C:\\nhttps://example.com\";}\n")
+	out := "a:2:{s:4:\"text\";s:28:\"line\nhttps://example.org/new\";s:4:\"path\";s:27:\"C:\\nhttps://example.org/new\";}\n"
+
+	replaced := fixLineWithEscaping(&in, []*Replacement{
+		{
+			From: []byte("https://example.com"),
+			To:   []byte("https://example.org/new"),
+		},
+	}, noneEscaping)
+
+	if string(*replaced) != out {
+		t.Error("Expected:", out, "Actual:", string(*replaced))
+	}
+}
+
+// runPipeline fixes every unit of a dump the way main does, one after another
+func runPipeline(dump []byte, replacements []*Replacement) ([]byte, error) {
+	r := newDumpReader(bufio.NewReader(bytes.NewReader(dump)), dialectAuto)
+
+	var out []byte
+	for {
+		unit, esc, err := r.next()
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(unit) > 0 && r.dialect == dialectSQLite {
+			out = append(out, *fixSQLiteStatement(&unit, replacements, esc)...)
+		} else if len(unit) > 0 {
+			out = append(out, *fixLineWithEscaping(&unit, replacements, esc)...)
+		}
+		if err == io.EOF {
+			return out, nil
+		}
+	}
+}
+
+func FuzzPipeline(f *testing.F) {
+	f.Add([]byte("INSERT INTO `wp_options` VALUES (1,'home','a:1:{i:0;s:25:\\\"http://uss-enterprise.com\\\";}');\n"))
+	f.Add([]byte("-- PostgreSQL database dump\nCOPY public.wp_options (option_value) FROM stdin;\ns:25:\"http://uss-enterprise.com\";\n\\.\n"))
+	f.Add([]byte("INSERT INTO \"wp_options\" VALUES('s:26:\"http://uss-enterprise.com\n\";');\n"))
+	f.Add([]byte("('s:25:\\\"http://uss-enterprise.com\\\";')\r\n('C:11:\\\"ArrayObject\\\":21:{x:i:0;a:0:{};m:a:0:{}}')"))
+
+	f.Fuzz(func(t *testing.T, dump []byte) {
+		unchanged, err := runPipeline(dump, nil)
+		if err != nil {
+			t.Skip(err)
+		}
+		if !bytes.Equal(unchanged, dump) {
+			t.Errorf("Expected %q to be left unchanged without replacements, got %q", dump, unchanged)
+		}
+
+		once, _ := runPipeline(dump, fuzzReplacements)
+		twice, _ := runPipeline(once, fuzzReplacements)
+		if !bytes.Equal(once, twice) {
+			t.Errorf("Expected replacing again to change nothing in %q, got %q", once, twice)
+		}
+	})
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// diffContext is how many unchanged bytes are shown around a change
+	diffContext = 40
+
+	// diffMaxChange is how many bytes of a single change are shown
+	diffMaxChange = 200
+
+	// resyncLength is how many equal bytes end a change, resyncLimit is how
+	// far ahead the end of a change is looked for
+	resyncLength = 8
+	resyncLimit  = 4096
+)
+
+var serializedLengthPrefixes = [][]byte{[]byte("s:"), []byte("C:")}
+
+// diffHunk replaces old[oldStart:oldEnd] with new[newStart:newEnd]
+type diffHunk struct {
+	oldStart, oldEnd int
+	newStart, newEnd int
+}
+
+// writeDiff writes the changes between the original and the rewritten unit
+// starting at the given line. Changes are shown as [-old-]{+new+} inside a
+// window of unchanged bytes, and changed serialized lengths are listed after
+// them.
+func writeDiff(w io.Writer, line int, old, new []byte) {
+	old = bytes.TrimRight(old, "\r\n")
+	new = bytes.TrimRight(new, "\r\n")
+
+	hunks := diffHunks(old, new)
+	if len(hunks) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "@@ line %d @@\n", line)
+
+	var lengths []string
+	for start := 0; start < len(hunks); {
+		end := start + 1
+		for end < len(hunks) && hunks[end].oldStart-hunks[end-1].oldEnd <= 2*diffContext {
+			end++
+		}
+
+		window := hunks[start:end]
+		from := window[0].oldStart - diffContext
+		if from < 0 {
+			from = 0
+		}
+		to := window[len(window)-1].oldEnd + diffContext
+		if to > len(old) {
+			to = len(old)
+		}
+		from, to = runeStart(old, from), runeStart(old, to)
+
+		var b strings.Builder
+		b.WriteString("  ")
+		if from > 0 {
+			b.WriteString("…")
+		}
+		last := from
+		for _, hunk := range window {
+			b.WriteString(showDiffText(old[last:hunk.oldStart], len(old)))
+			if hunk.oldEnd > hunk.oldStart {
+				b.WriteString("[-" + showDiffText(old[hunk.oldStart:hunk.oldEnd], diffMaxChange) + "-]")
+			}
+			if hunk.newEnd > hunk.newStart {
+				b.WriteString("{+" + showDiffText(new[hunk.newStart:hunk.newEnd], diffMaxChange) + "+}")
+			}
+			last = hunk.oldEnd
+
+			if isLengthHunk(old[hunk.oldStart:hunk.oldEnd], new[hunk.newStart:hunk.newEnd]) {
+				lengths = append(lengths, string(old[hunk.oldStart:hunk.oldEnd])+"->"+string(new[hunk.newStart:hunk.newEnd]))
+			}
+		}
+		b.WriteString(showDiffText(old[last:to], len(old)))
+		if to < len(old) {
+			b.WriteString("…")
+		}
+		fmt.Fprintln(w, b.String())
+
+		start = end
+	}
+
+	if len(lengths) > 0 {
+		fmt.Fprintf(w, "  serialized lengths: %s\n", strings.Join(lengths, ", "))
+	}
+}
+
+// diffHunks finds the changed parts of a unit. Changes made by replacing are
+// short compared to the unit, so after every difference the bytes are matched
+// up again at the closest point where resyncLength bytes are equal.
+func diffHunks(old, new []byte) []diffHunk {
+	prefix := 0
+	for prefix < len(old) && prefix < len(new) && old[prefix] == new[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(new)-prefix && old[len(old)-1-suffix] == new[len(new)-1-suffix] {
+		suffix++
+	}
+	oldEnd, newEnd := len(old)-suffix, len(new)-suffix
+
+	var hunks []diffHunk
+	for i, j := prefix, prefix; i < oldEnd || j < newEnd; {
+		if i < oldEnd && j < newEnd && old[i] == new[j] {
+			i++
+			j++
+			continue
+		}
+
+		a, b, ok := resync(old[i:oldEnd], new[j:newEnd])
+		if !ok {
+			a, b = oldEnd-i, newEnd-j
+		}
+		hunk := diffHunk{i, i + a, j, j + b}
+		if length, ok := leadingLengthHunk(old, new, hunk); ok {
+			hunk = length
+		} else {
+			hunk = widenRuneHunk(old, new, widenLengthHunk(old, new, hunk))
+		}
+		hunks = append(hunks, hunk)
+		i, j = hunk.oldEnd, hunk.newEnd
+	}
+
+	return hunks
+}
+
+// resync returns the shortest skips in old and new after which both continue
+// with the same bytes
+func resync(old, new []byte) (int, int, bool) {
+	for k := 1; k <= resyncLimit; k++ {
+		for a := 0; a <= k; a++ {
+			b := k - a
+			if a > len(old) || b > len(new) {
+				continue
+			}
+
+			o, n := old[a:], new[b:]
+			if len(o) < resyncLength || len(n) < resyncLength {
+				if bytes.Equal(o, n) {
+					return a, b, true
+				}
+				continue
+			}
+			if bytes.Equal(o[:resyncLength], n[:resyncLength]) {
+				return a, b, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// widenLengthHunk makes a change inside a number cover the whole number, and
+// the s: in front of it if it is a serialized length, so 2[-6-]{+4+} is shown
+// as [-s:26-]{+s:24+}
+func widenLengthHunk(old, new []byte, hunk diffHunk) diffHunk {
+	if !isDigits(old[hunk.oldStart:hunk.oldEnd]) || !isDigits(new[hunk.newStart:hunk.newEnd]) {
+		return hunk
+	}
+
+	// the bytes before and after the hunk are the same in old and new
+	for hunk.oldStart > 0 && isDigit(old[hunk.oldStart-1]) {
+		hunk.oldStart--
+		hunk.newStart--
+	}
+	for hunk.oldEnd < len(old) && hunk.newEnd < len(new) && isDigit(old[hunk.oldEnd]) && old[hunk.oldEnd] == new[hunk.newEnd] {
+		hunk.oldEnd++
+		hunk.newEnd++
+	}
+
+	for _, prefix := range serializedLengthPrefixes {
+		if bytes.HasSuffix(old[:hunk.oldStart], prefix) {
+			hunk.oldStart -= len(prefix)
+			hunk.newStart -= len(prefix)
+			break
+		}
+	}
+
+	return hunk
+}
+
+// leadingLengthHunk returns the serialized length a change starts in, when
+// the change goes on past it. Few equal bytes can follow a length, e.g.
+// s:14:\"http://old.com turning into s:15:\"https://new.org, so the length
+// would otherwise be shown as part of the change after it.
+func leadingLengthHunk(old, new []byte, hunk diffHunk) (diffHunk, bool) {
+	// the bytes before the hunk are the same in old and new
+	start := hunk.oldStart
+	for start > 0 && isDigit(old[start-1]) {
+		start--
+	}
+
+	prefixLength := 0
+	for _, prefix := range serializedLengthPrefixes {
+		if bytes.HasSuffix(old[:start], prefix) {
+			prefixLength = len(prefix)
+			break
+		}
+	}
+	if prefixLength == 0 {
+		return hunk, false
+	}
+
+	oldEnd, newEnd := hunk.oldStart, hunk.newStart
+	for oldEnd < hunk.oldEnd && isDigit(old[oldEnd]) {
+		oldEnd++
+	}
+	for newEnd < hunk.newEnd && isDigit(new[newEnd]) {
+		newEnd++
+	}
+	if oldEnd == hunk.oldStart && newEnd == hunk.newStart || oldEnd == hunk.oldEnd && newEnd == hunk.newEnd {
+		return hunk, false
+	}
+
+	back := hunk.oldStart - start + prefixLength
+	return diffHunk{hunk.oldStart - back, oldEnd, hunk.newStart - back, newEnd}, true
+}
+
+// widenRuneHunk makes a change that starts or ends inside a multibyte
+// character cover the whole character, so é replaced with è is shown as
+// [-é-]{+è+} rather than as two halves of invalid UTF-8
+func widenRuneHunk(old, new []byte, hunk diffHunk) diffHunk {
+	// the bytes before and after the hunk are the same in old and new
+	for k := 1; k < utf8.UTFMax && hunk.oldStart > 0 && hunk.newStart > 0; k++ {
+		if startsRune(old, hunk.oldStart) && startsRune(new, hunk.newStart) {
+			break
+		}
+		hunk.oldStart--
+		hunk.newStart--
+	}
+	for k := 1; k < utf8.UTFMax && hunk.oldEnd < len(old) && hunk.newEnd < len(new); k++ {
+		if startsRune(old, hunk.oldEnd) && startsRune(new, hunk.newEnd) {
+			break
+		}
+		hunk.oldEnd++
+		hunk.newEnd++
+	}
+	return hunk
+}
+
+// startsRune tells whether a character can start at b[i], the end of b
+// included
+func startsRune(b []byte, i int) bool {
+	return i >= len(b) || utf8.RuneStart(b[i])
+}
+
+// runeStart moves i back to the start of the character it is in
+func runeStart(b []byte, i int) int {
+	for k := 1; k < utf8.UTFMax && i > 0 && !startsRune(b, i); k++ {
+		i--
+	}
+	return i
+}
+
+func isLengthHunk(old, new []byte) bool {
+	for _, prefix := range serializedLengthPrefixes {
+		if bytes.HasPrefix(old, prefix) && bytes.HasPrefix(new, prefix) {
+			return isDigits(old[len(prefix):]) && isDigits(new[len(prefix):])
+		}
+	}
+	return false
+}
+
+func isDigits(b []byte) bool {
+	for _, c := range b {
+		if !isDigit(c) {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// showDiffText shortens text to max bytes and makes line breaks visible
+func showDiffText(text []byte, max int) string {
+	shown := string(text)
+	if len(text) > max {
+		shown = string(text[:runeStart(text, max/2)]) + "…" + string(text[runeStart(text, len(text)-max/2):])
+	}
+	return strings.NewReplacer("\r", "␍", "\n", "↵").Replace(shown)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteDiff(t *testing.T) {
+	long := strings.Repeat("x", 100)
+
+	var tests = []struct {
+		testName string
+		line     int
+		old      string
+		new      string
+		diff     string
+	}{
+		{
+			testName: "serialized length",
+			line:     3,
+			old:      `('s:26:\"https://uss-enterprise.com\";')` + "\n",
+			new:      `('s:24:\"https://ncc-1701-d.space\";')` + "\n",
+			diff: "@@ line 3 @@\n" +
+				`  ('[-s:26-]{+s:24+}:\"https://[-uss-enterprise.com-]{+ncc-1701-d.space+}\";')` + "\n" +
+				"  serialized lengths: s:26->s:24\n",
+		},
+		{
+			testName: "serialized length followed by few equal bytes",
+			line:     5,
+			old:      `('s:25:\"http://uss-enterprise.com\";')` + "\n",
+			new:      `('s:26:\"https://uss-enterprise.com\";')` + "\n",
+			diff: "@@ line 5 @@\n" +
+				`  ('[-s:25-]{+s:26+}:\"http{+s+}://uss-enterprise.com\";')` + "\n" +
+				"  serialized lengths: s:25->s:26\n",
+		},
+		{
+			testName: "windows around changes far apart",
+			line:     1,
+			old:      "https://uss-enterprise.com," + long + ",https://uss-enterprise.com",
+			new:      "https://ncc-1701-d.space," + long + ",https://ncc-1701-d.space",
+			diff: "@@ line 1 @@\n" +
+				"  https://[-uss-enterprise.com-]{+ncc-1701-d.space+}," + long[:39] + "…\n" +
+				"  …" + long[:31] + ",https://[-uss-enterprise.com-]{+ncc-1701-d.space+}\n",
+		},
+		{
+			testName: "multibyte characters",
+			line:     4,
+			old:      `('s:5:\"café\";')`,
+			new:      `('s:5:\"cafè\";')`,
+			diff: "@@ line 4 @@\n" +
+				`  ('s:5:\"caf[-é-]{+è+}\";')` + "\n",
+		},
+		{
+			testName: "window starting inside a multibyte character",
+			line:     1,
+			old:      strings.Repeat("日", 20) + "https://uss-enterprise.com",
+			new:      strings.Repeat("日", 20) + "https://ncc-1701-d.space",
+			diff: "@@ line 1 @@\n" +
+				"  …" + strings.Repeat("日", 11) + "https://[-uss-enterprise.com-]{+ncc-1701-d.space+}\n",
+		},
+		{
+			testName: "inserted and removed text",
+			line:     7,
+			old:      "('https://uss-enterprise.com/decks')",
+			new:      "('/decks')",
+			diff: "@@ line 7 @@\n" +
+				"  ('[-https://uss-enterprise.com-]/decks')\n",
+		},
+		{
+			testName: "line breaks",
+			line:     2,
+			old:      "'first\nhttps://uss-enterprise.com'\n",
+			new:      "'first\nhttps://ncc-1701-d.space'\n",
+			diff: "@@ line 2 @@\n" +
+				"  'first↵https://[-uss-enterprise.com-]{+ncc-1701-d.space+}'\n",
+		},
+		{
+			testName: "unchanged",
+			line:     1,
+			old:      "('https://ncc-1701-d.space')\n",
+			new:      "('https://ncc-1701-d.space')\n",
+			diff:     "",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			var out bytes.Buffer
+			writeDiff(&out, test.line, []byte(test.old), []byte(test.new))
+
+			if out.String() != test.diff {
+				t.Error("Expected:", test.diff, "Actual:", out.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"regexp"
+)
+
+// escaping describes how the bytes of a string are written in the input.
+// Serialized lengths count the bytes PHP gets back once the data is loaded, so
+// the scanner has to know which sequences stand for a single byte.
+type escaping struct {
+	name string
+
+	// quote is how a double quote inside a string is written
+	quote []byte
+
+	// decode returns the number of input bytes taken by the (possibly escaped)
+	// sequence at the start of b, and the bytes it stands for
+	decode func(b []byte) (int, []byte)
+
+	// escape encodes raw bytes
+	escape func(raw []byte) []byte
+
+	stringPrefix *regexp.Regexp
+	customPrefix *regexp.Regexp
+}
+
+func newEscaping(name, quote string, classRe string, decode func([]byte) (int, []byte), escape func([]byte) []byte) *escaping {
+	q := regexp.QuoteMeta(quote)
+	return &escaping{
+		name:         name,
+		quote:        []byte(quote),
+		decode:       decode,
+		escape:       escape,
+		stringPrefix: regexp.MustCompile(`s:(\d+):` + q),
+		customPrefix: regexp.MustCompile(`C:(\d+):` + q + classRe + q + `:(\d+):\{`),
+	}
+}
+
+var (
+	// mysqlEscaping is used by mysqldump and mydumper: backslash escapes,
+	// double quotes included
+	mysqlEscaping = newEscaping("mysql", `\"`, `((?:[^"\\]|\\\\)*)`, decodeMySQL, mysqlEscape)
+
+	// copyEscaping is the text format of PostgreSQL's COPY ... FROM stdin:
+	// backslash escapes, but quotes are written as they are
+	copyEscaping = newEscaping("postgres", `"`, `((?:[^"\\]|\\\\)*)`, decodeCopy, copyEscape)
+
+	// standardEscaping is standard SQL quoting, as in PostgreSQL with
+	// standard_conforming_strings on: a quote is doubled, backslashes are
+	// ordinary characters
+	standardEscaping = newEscaping("standard", `"`, `([^"]*)`, decodeStandard, standardEscape)
+
+	// outfileEscaping is used by SELECT ... INTO OUTFILE and LOAD DATA with
+	// the default ESCAPED BY '\\': like MySQL, but a backslash in front of a
+	// tab or line break stands for that character
+	outfileEscaping = newEscaping("outfile", `"`, `((?:[^"\\]|\\\\)*)`, decodeOutfile, outfileEscape)
+
+	// noneEscaping is for values that are written as they are
+	noneEscaping = newEscaping("none", `"`, `([^"]*)`, decodeNone, escapeNone)
+)
+
+// escapings can be chosen with -escaping, overriding the one of the format
+var escapings = map[string]*escaping{
+	noneEscaping.name:  noneEscaping,
+	mysqlEscaping.name: mysqlEscaping,
+	copyEscaping.name:  copyEscaping,
+}
+
+// unescape returns the bytes escaped stands for
+func (e *escaping) unescape(escaped []byte) []byte {
+	unescaped := make([]byte, 0, len(escaped))
+	for index := 0; index < len(escaped); {
+		width, decoded := e.decode(escaped[index:])
+		unescaped = append(unescaped, decoded...)
+		index += width
+	}
+	return unescaped
+}
+
+// unescapeWithOffsets is unescape that also returns, for every unescaped byte,
+// the index in escaped it came from. The extra last offset is len(escaped).
+func (e *escaping) unescapeWithOffsets(escaped []byte) ([]byte, []int) {
+	unescaped := make([]byte, 0, len(escaped))
+	offsets := make([]int, 0, len(escaped)+1)
+
+	for index := 0; index < len(escaped); {
+		width, decoded := e.decode(escaped[index:])
+		for range decoded {
+			offsets = append(offsets, index)
+		}
+		unescaped = append(unescaped, decoded...)
+		index += width
+	}
+
+	return unescaped, append(offsets, len(escaped))
+}
+
+func decodeMySQL(b []byte) (int, []byte) {
+	if b[0] == '\\' && len(b) > 1 {
+		return 2, getUnescapedBytesIfEscaped(b[:2])
+	}
+	return 1, b[:1]
+}
+
+// mysqlEscape escapes raw bytes the way mysqldump does
+func mysqlEscape(raw []byte) []byte {
+	escaped := make([]byte, 0, len(raw)+len(raw)/8)
+	for _, c := range raw {
+		switch c {
+		case '\\', '\'', '"':
+			escaped = append(escaped, '\\', c)
+		case '\n':
+			escaped = append(escaped, '\\', 'n')
+		case '\r':
+			escaped = append(escaped, '\\', 'r')
+		case 0:
+			escaped = append(escaped, '\\', '0')
+		default:
+			escaped = append(escaped, c)
+		}
+	}
+	return escaped
+}
+
+var copyEscapes = map[byte]byte{
+	'b': '\b',
+	'f': '\f',
+	'n': '\n',
+	'r': '\r',
+	't': '\t',
+	'v': '\v',
+}
+
+// decodeCopy decodes the escapes of COPY's text format: \n style control
+// characters, \NNN octal and \xHH hexadecimal bytes, and a backslash in front
+// of any other character standing for that character
+func decodeCopy(b []byte) (int, []byte) {
+	if b[0] != '\\' || len(b) < 2 {
+		return 1, b[:1]
+	}
+
+	if c, ok := copyEscapes[b[1]]; ok {
+		return 2, []byte{c}
+	}
+
+	if '0' <= b[1] && b[1] <= '7' {
+		value, width := 0, 1
+		for width < 4 && width < len(b) && '0' <= b[width] && b[width] <= '7' {
+			value = value*8 + int(b[width]-'0')
+			width++
+		}
+		return width, []byte{byte(value)}
+	}
+
+	if b[1] == 'x' && len(b) > 2 {
+		if value, ok := hexValue(b[2]); ok {
+			width := 3
+			if len(b) > 3 {
+				if low, ok := hexValue(b[3]); ok {
+					value, width = value*16+low, 4
+				}
+			}
+			return width, []byte{byte(value)}
+		}
+	}
+
+	return 2, b[1:2]
+}
+
+// copyEscape escapes raw bytes the way COPY ... TO stdout does
+func copyEscape(raw []byte) []byte {
+	escaped := make([]byte, 0, len(raw)+len(raw)/8)
+	for _, c := range raw {
+		switch c {
+		case '\\':
+			escaped = append(escaped, '\\', '\\')
+		case '\b':
+			escaped = append(escaped, '\\', 'b')
+		case '\f':
+			escaped = append(escaped, '\\', 'f')
+		case '\n':
+			escaped = append(escaped, '\\', 'n')
+		case '\r':
+			escaped = append(escaped, '\\', 'r')
+		case '\t':
+			escaped = append(escaped, '\\', 't')
+		case '\v':
+			escaped = append(escaped, '\\', 'v')
+		default:
+			escaped = append(escaped, c)
+		}
+	}
+	return escaped
+}
+
+func decodeStandard(b []byte) (int, []byte) {
+	if b[0] == '\'' && len(b) > 1 && b[1] == '\'' {
+		return 2, b[:1]
+	}
+	return 1, b[:1]
+}
+
+func standardEscape(raw []byte) []byte {
+	escaped := make([]byte, 0, len(raw)+len(raw)/8)
+	for _, c := range raw {
+		if c == '\'' {
+			escaped = append(escaped, '\'')
+		}
+		escaped = append(escaped, c)
+	}
+	return escaped
+}
+
+var outfileEscapes = map[byte]byte{
+	'0': 0,
+	'b': '\b',
+	'n': '\n',
+	'r': '\r',
+	't': '\t',
+	'Z': '\x1a',
+}
+
+func decodeOutfile(b []byte) (int, []byte) {
+	if b[0] != '\\' || len(b) < 2 {
+		return 1, b[:1]
+	}
+	if c, ok := outfileEscapes[b[1]]; ok {
+		return 2, []byte{c}
+	}
+	return 2, b[1:2]
+}
+
+// outfileEscape escapes raw bytes the way SELECT ... INTO OUTFILE does
+func outfileEscape(raw []byte) []byte {
+	escaped := make([]byte, 0, len(raw)+len(raw)/8)
+	for _, c := range raw {
+		switch c {
+		case '\\', '\t', '\n':
+			escaped = append(escaped, '\\', c)
+		case 0:
+			escaped = append(escaped, '\\', '0')
+		default:
+			escaped = append(escaped, c)
+		}
+	}
+	return escaped
+}
+
+func decodeNone(b []byte) (int, []byte) {
+	return 1, b[:1]
+}
+
+func escapeNone(raw []byte) []byte {
+	return raw
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// jsonAware enables decoding JSON documents found inside values, so that
+// escaped forms such as https:\/\/example.com or caf\u00e9 are matched too
+var jsonAware bool
+
+const hexDigits = "0123456789abcdef"
+
+// jsonStyle records how a JSON document was encoded, so rewritten strings can
+// be encoded the same way
+type jsonStyle struct {
+	escapeSlashes bool
+	escapeUnicode bool
+	upperHex      bool
+
+	// escapedASCII marks ASCII characters written as \u00XX, e.g. the
+	// < and " used in block editor comment attributes
+	escapedASCII [utf8.RuneSelf]bool
+}
+
+// jsonEdit replaces doc[start:end] with value
+type jsonEdit struct {
+	start int
+	end   int
+	value []byte
+}
+
+// replaceJSONAware applies the replacements to part, which is escaped with
+// esc. String values of JSON documents are decoded before replacing and
+// encoded again afterwards; everything else is replaced as usual.
+func replaceJSONAware(part []byte, replacements []*Replacement, esc *escaping) []byte {
+	raw, offsets := esc.unescapeWithOffsets(part)
+
+	var rebuilt []byte
+	last := 0
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '{' && raw[i] != '[' {
+			continue
+		}
+
+		length := jsonDocumentLength(raw[i:])
+		if length == 0 {
+			continue
+		}
+
+		edits := jsonStringEdits(raw[i:i+length], replacements)
+		if len(edits) > 0 {
+			rebuilt = append(rebuilt, replacePlain(part[last:offsets[i]], replacements, esc)...)
+			last = offsets[i]
+
+			for _, edit := range edits {
+				start, end := offsets[i+edit.start], offsets[i+edit.end]
+				rebuilt = append(rebuilt, convertText(part[last:start])...)
+				rebuilt = append(rebuilt, esc.escape(edit.value)...)
+				last = end
+			}
+
+			rebuilt = append(rebuilt, convertText(part[last:offsets[i+length]])...)
+			last = offsets[i+length]
+		} else {
+			// unchanged documents are copied as they are; running the plain
+			// replacements over them would bypass the JSON decoding
+			rebuilt = append(rebuilt, replacePlain(part[last:offsets[i]], replacements, esc)...)
+			rebuilt = append(rebuilt, convertText(part[offsets[i]:offsets[i+length]])...)
+			last = offsets[i+length]
+		}
+
+		i += length - 1
+	}
+
+	return append(rebuilt, replacePlain(part[last:], replacements, esc)...)
+}
+
+// jsonDocumentLength returns the length of the JSON object or array at the
+// start of b, or 0 if b doesn't start with one
+func jsonDocumentLength(b []byte) int {
+	next := nextNonSpace(b, 1)
+	if next < 0 {
+		return 0
+	}
+
+	switch b[0] {
+	case '{':
+		if b[next] != '"' && b[next] != '}' {
+			return 0
+		}
+	case '[':
+		if !bytes.ContainsRune([]byte(`"{[]-0123456789tfn`), rune(b[next])) {
+			return 0
+		}
+	default:
+		return 0
+	}
+
+	var doc json.RawMessage
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	if err := decoder.Decode(&doc); err != nil {
+		return 0
+	}
+
+	return int(decoder.InputOffset())
+}
+
+// jsonStringEdits finds the string values of a JSON document that change when
+// the replacements are applied to their decoded form. Object keys are kept.
+func jsonStringEdits(doc []byte, replacements []*Replacement) []jsonEdit {
+	style := detectJSONStyle(doc)
+
+	var edits []jsonEdit
+	for i := 0; i < len(doc); i++ {
+		if doc[i] != '"' {
+			continue
+		}
+
+		end := jsonStringEnd(doc, i)
+		next := nextNonSpace(doc, end)
+		isKey := next >= 0 && doc[next] == ':'
+
+		if !isKey {
+			// the conversion applies to the bytes as written: a \u00e9 escape
+			// is Unicode already, whatever the character set of the input
+			decoded := decodeJSONString(convertText(doc[i+1 : end-1]))
+			replaced := replaceConverted(decoded, replacements, noneEscaping)
+			if !bytes.Equal(decoded, replaced) {
+				edits = append(edits, jsonEdit{
+					start: i,
+					end:   end,
+					value: encodeJSONString(replaced, &style),
+				})
+			}
+		}
+
+		i = end - 1
+	}
+
+	return edits
+}
+
+// jsonStringEnd returns the index after the closing quote of the string that
+// starts at doc[start]
+func jsonStringEnd(doc []byte, start int) int {
+	for i := start + 1; i < len(doc); i++ {
+		switch doc[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(doc)
+}
+
+func nextNonSpace(b []byte, from int) int {
+	for i := from; i < len(b); i++ {
+		switch b[i] {
+		case ' ', '\t', '\n', '\r':
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+func detectJSONStyle(doc []byte) jsonStyle {
+	var style jsonStyle
+	for i := 0; i+1 < len(doc); i++ {
+		if doc[i] != '\\' {
+			continue
+		}
+
+		switch doc[i+1] {
+		case '/':
+			style.escapeSlashes = true
+		case 'u':
+			if i+6 > len(doc) {
+				break
+			}
+			code, ok := parseHex4(doc[i+2 : i+6])
+			if !ok {
+				break
+			}
+			if code < utf8.RuneSelf {
+				style.escapedASCII[code] = true
+			} else {
+				style.escapeUnicode = true
+			}
+			if bytes.ContainsAny(doc[i+2:i+6], "ABCDEF") {
+				style.upperHex = true
+			}
+		}
+		i++
+	}
+
+	return style
+}
+
+// decodeJSONString decodes the contents of a JSON string. Bytes that aren't
+// part of an escape sequence are kept as they are, even if they are not valid
+// UTF-8, so unchanged content survives a round trip.
+func decodeJSONString(s []byte) []byte {
+	decoded := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 >= len(s) {
+			decoded = append(decoded, s[i])
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case 'b':
+			decoded = append(decoded, '\b')
+		case 'f':
+			decoded = append(decoded, '\f')
+		case 'n':
+			decoded = append(decoded, '\n')
+		case 'r':
+			decoded = append(decoded, '\r')
+		case 't':
+			decoded = append(decoded, '\t')
+		case 'u':
+			r, width := decodeJSONUnicode(s[i-1:])
+			decoded = utf8.AppendRune(decoded, r)
+			i += width - 2
+		default:
+			decoded = append(decoded, s[i])
+		}
+	}
+	return decoded
+}
+
+// decodeJSONUnicode decodes the \uXXXX escape (or surrogate pair) at the start
+// of s and returns the rune and the number of bytes it took
+func decodeJSONUnicode(s []byte) (rune, int) {
+	if len(s) < 6 {
+		return utf8.RuneError, len(s)
+	}
+
+	code, ok := parseHex4(s[2:6])
+	if !ok {
+		return utf8.RuneError, 6
+	}
+
+	r := rune(code)
+	if utf16.IsSurrogate(r) && len(s) >= 12 && s[6] == '\\' && s[7] == 'u' {
+		if low, ok := parseHex4(s[8:12]); ok {
+			if combined := utf16.DecodeRune(r, rune(low)); combined != utf8.RuneError {
+				return combined, 12
+			}
+		}
+	}
+
+	return r, 6
+}
+
+func parseHex4(b []byte) (int, bool) {
+	code := 0
+	for _, c := range b {
+		value, ok := hexValue(c)
+		if !ok {
+			return 0, false
+		}
+		code = code<<4 | value
+	}
+	return code, true
+}
+
+func hexValue(c byte) (int, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c - '0'), true
+	case 'a' <= c && c <= 'f':
+		return int(c-'a') + 10, true
+	case 'A' <= c && c <= 'F':
+		return int(c-'A') + 10, true
+	}
+	return 0, false
+}
+
+// encodeJSONString encodes s as a quoted JSON string using the given style
+func encodeJSONString(s []byte, style *jsonStyle) []byte {
+	encoded := make([]byte, 0, len(s)+2)
+	encoded = append(encoded, '"')
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c >= utf8.RuneSelf {
+			r, width := utf8.DecodeRune(s[i:])
+			if r == utf8.RuneError && width == 1 || !style.escapeUnicode {
+				encoded = append(encoded, s[i:i+width]...)
+			} else if r1, r2 := utf16.EncodeRune(r); r1 != utf8.RuneError {
+				encoded = appendUnicodeEscape(encoded, int(r1), style)
+				encoded = appendUnicodeEscape(encoded, int(r2), style)
+			} else {
+				encoded = appendUnicodeEscape(encoded, int(r), style)
+			}
+			i += width
+			continue
+		}
+
+		switch {
+		case style.escapedASCII[c]:
+			encoded = appendUnicodeEscape(encoded, int(c), style)
+		case c == '"' || c == '\\':
+			encoded = append(encoded, '\\', c)
+		case c == '/' && style.escapeSlashes:
+			encoded = append(encoded, '\\', '/')
+		case c == '\n':
+			encoded = append(encoded, '\\', 'n')
+		case c == '\r':
+			encoded = append(encoded, '\\', 'r')
+		case c == '\t':
+			encoded = append(encoded, '\\', 't')
+		case c == '\b':
+			encoded = append(encoded, '\\', 'b')
+		case c == '\f':
+			encoded = append(encoded, '\\', 'f')
+		case c < ' ':
+			encoded = appendUnicodeEscape(encoded, int(c), style)
+		default:
+			encoded = append(encoded, c)
+		}
+		i++
+	}
+
+	return append(encoded, '"')
+}
+
+func appendUnicodeEscape(b []byte, code int, style *jsonStyle) []byte {
+	digits := hexDigits
+	if style.upperHex {
+		digits = "0123456789ABCDEF"
+	}
+	return append(b, '\\', 'u', digits[code>>12&0xf], digits[code>>8&0xf], digits[code>>4&0xf], digits[code&0xf])
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReplaceJSONAware(t *testing.T) {
+	var tests = []struct {
+		testName string
+		in       []byte
+		out      []byte
+		from     []byte
+		to       []byte
+	}{
+		{
+			testName: "escaped slashes",
+
+			from: []byte("https://example.com"),
+			to:   []byte("https://example.org"),
+
+			in:  []byte(`('{\"url\":\"https:\\/\\/example.com\\/page\",\"id\":1}')`),
+			out: []byte(`('{\"url\":\"https:\\/\\/example.org\\/page\",\"id\":1}')`),
+		},
+		{
+			testName: "unicode escapes are kept for new content",
+
+			from: []byte("https://example.com"),
+			to:   []byte("https://müller.de"),
+
+			in:  []byte(`('{\"title\":\"caf\\u00e9\",\"url\":\"https:\\/\\/example.com\"}')`),
+			out: []byte(`('{\"title\":\"caf\\u00e9\",\"url\":\"https:\\/\\/m\\u00fcller.de\"}')`),
+		},
+		{
+			testName: "unicode escapes are matched",
+
+			from: []byte("https://müller.de"),
+			to:   []byte("https://example.com"),
+
+			in:  []byte(`('[\"https:\\/\\/m\\u00FCller.de\",\"https:\\/\\/m\\u00FCller.de\\/shop\"]')`),
+			out: []byte(`('[\"https:\\/\\/example.com\",\"https:\\/\\/example.com\\/shop\"]')`),
+		},
+		{
+			testName: "block editor comment",
+
+			from: []byte("https://example.com"),
+			to:   []byte("https://example.org"),
+
+			in:  []byte(`('<!-- wp:image {\"url\":\"https://example.com/a.jpg\",\"caption\":\"\\u003cb\\u003eA\\u003c/b\\u003e\"} --><img src=\"https://example.com/a.jpg\"/>')`),
+			out: []byte(`('<!-- wp:image {\"url\":\"https://example.org/a.jpg\",\"caption\":\"\\u003cb\\u003eA\\u003c/b\\u003e\"} --><img src=\"https://example.org/a.jpg\"/>')`),
+		},
+		{
+			testName: "object keys are kept",
+
+			from: []byte("example.com"),
+			to:   []byte("example.org"),
+
+			in:  []byte(`('{\"example.com\":\"example.com\"}')`),
+			out: []byte(`('{\"example.com\":\"example.org\"}')`),
+		},
+		{
+			testName: "JSON inside a serialized string",
+
+			from: []byte("https://example.com"),
+			to:   []byte("https://example.org/wp"),
+
+			in:  []byte(`a:1:{s:4:\"json\";s:37:\"{\"url\":\"https:\\/\\/example.com\\/page\"}\";}`),
+			out: []byte(`a:1:{s:4:\"json\";s:41:\"{\"url\":\"https:\\/\\/example.org\\/wp\\/page\"}\";}`),
+		},
+		{
+			testName: "serialized arrays are not JSON",
+
+			from: []byte("https://example.com"),
+			to:   []byte("https://example.org"),
+
+			in:  []byte(`('a:1:{i:0;s:19:\"https://example.com\";}','[1,2]')`),
+			out: []byte(`('a:1:{i:0;s:19:\"https://example.org\";}','[1,2]')`),
+		},
+	}
+
+	jsonAware = true
+	defer func() { jsonAware = false }()
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			replaced := fixLine(&test.in, []*Replacement{
+				{
+					From: test.from,
+					To:   test.to,
+				},
+			})
+
+			if !bytes.Equal(*replaced, test.out) {
+				t.Error("Expected:", string(test.out), "Actual:", string(*replaced))
+			}
+		})
+	}
+}
+
+func TestJSONStringRoundTrip(t *testing.T) {
+	var tests = []struct {
+		testName string
+		in       string
+	}{
+		{
+			testName: "plain",
+			in:       `"https://example.com"`,
+		},
+		{
+			testName: "escaped slashes",
+			in:       `"https:\/\/example.com\/"`,
+		},
+		{
+			testName: "unicode escapes and surrogate pair",
+			in:       `"caf\u00e9 \ud83d\udd96"`,
+		},
+		{
+			testName: "escaped markup and quotes",
+			in:       `"\u003Ca href=\u0022x\u0022\u003E\n"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			doc := []byte(test.in)
+			style := detectJSONStyle(doc)
+			encoded := encodeJSONString(decodeJSONString(doc[1:len(doc)-1]), &style)
+
+			if string(encoded) != test.in {
+				t.Error("Expected:", test.in, "Actual:", string(encoded))
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+const formatJSONL = "jsonl"
+
+// lineReader returns the input line by line, all with the same escaping
+type lineReader struct {
+	r   *bufio.Reader
+	esc *escaping
+}
+
+func (l *lineReader) next() ([]byte, *escaping, error) {
+	line, err := l.r.ReadBytes('\n')
+	return line, l.esc, err
+}
+
+// jsonlFormat describes JSON Lines input, one JSON object per line
+type jsonlFormat struct {
+	// keys limits replacing to the values of these top level keys, nil means
+	// all of them
+	keys map[string]bool
+}
+
+// newJSONLFormat returns the settings for a comma separated list of keys
+func newJSONLFormat(keys string) *jsonlFormat {
+	f := &jsonlFormat{}
+	if keys == "" {
+		return f
+	}
+
+	f.keys = make(map[string]bool)
+	for _, key := range strings.Split(keys, ",") {
+		f.keys[strings.TrimSpace(key)] = true
+	}
+	return f
+}
+
+// fixRecord applies the replacements to the string values of a JSON object and
+// fixes the serialized data inside them. The values are decoded before
+// replacing, so lengths are counted in the bytes PHP gets back. Keys, their
+// order, numbers and whitespace are kept as they are, apart from the -charset
+// conversion, which covers the whole line. Lines that aren't JSON objects are
+// counted and written back unchanged.
+func (f *jsonlFormat) fixRecord(line *[]byte, replacements []*Replacement, esc *escaping) *[]byte {
+	body, ok := jsonObject(*line)
+	if !ok {
+		if len(body) > 0 {
+			stats.invalidRecords.Add(1)
+		}
+		return line
+	}
+
+	style := detectJSONStyle(body)
+
+	var edits []jsonEdit
+	for _, value := range f.stringValues(body) {
+		decoded, valueEsc := append([]byte{}, value.value...), esc
+		if convertCharset != nil && esc == noneEscaping {
+			decoded, valueEsc = copyEscapedJSONString(body[value.start+1:value.end-1]), copyEscaping
+		}
+		fixed := valueEsc.unescape(*fixLineWithEscaping(&decoded, replacements, valueEsc))
+		if !bytes.Equal(fixed, value.value) {
+			edits = append(edits, jsonEdit{
+				start: value.start,
+				end:   value.end,
+				value: encodeJSONString(fixed, &style),
+			})
+		}
+	}
+
+	if len(edits) == 0 && convertCharset == nil {
+		return line
+	}
+
+	var rebuilt []byte
+	last := 0
+	for _, edit := range edits {
+		rebuilt = append(rebuilt, convertText(body[last:edit.start])...)
+		rebuilt = append(rebuilt, edit.value...)
+		last = edit.end
+	}
+	rebuilt = append(rebuilt, convertText((*line)[last:])...)
+
+	return &rebuilt
+}
+
+// values returns the decoded string values of a record that replacing applies to
+func (f *jsonlFormat) values(line []byte, esc *escaping) [][]byte {
+	body, ok := jsonObject(line)
+	if !ok {
+		return nil
+	}
+
+	var values [][]byte
+	for _, value := range f.stringValues(body) {
+		values = append(values, esc.unescape(value.value))
+	}
+	return values
+}
+
+// jsonObject returns the line without its line ending, and whether it is a
+// JSON object. A blank line is returned empty.
+func jsonObject(line []byte) ([]byte, bool) {
+	body := bytes.TrimRight(line, "\r\n")
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, false
+	}
+
+	start := nextNonSpace(body, 0)
+	return body, body[start] == '{' && json.Valid(body)
+}
+
+// stringValues finds the string values of a JSON object that belong to the
+// selected keys, as jsonEdits holding their decoded contents
+func (f *jsonlFormat) stringValues(body []byte) []jsonEdit {
+	var values []jsonEdit
+	depth := 0
+	key := ""
+	for i := nextNonSpace(body, 0); i < len(body); i++ {
+		switch body[i] {
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+		case '"':
+			end := jsonStringEnd(body, i)
+			decoded := decodeJSONString(body[i+1 : end-1])
+
+			if next := nextNonSpace(body, end); body[next] == ':' {
+				if depth == 1 {
+					key = string(decoded)
+				}
+			} else if f.keys == nil || f.keys[key] {
+				values = append(values, jsonEdit{start: i, end: end, value: decoded})
+			}
+
+			i = end - 1
+		}
+	}
+	return values
+}
+
+// copyEscapedJSONString decodes the contents of a JSON string into COPY's
+// escaping, with the bytes of the characters of \u escapes written as \xHH.
+// -charset converts the bytes as they were written and leaves those characters
+// alone, since they are Unicode already, while serialized lengths are still
+// counted in decoded bytes.
+func copyEscapedJSONString(s []byte) []byte {
+	escaped := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && s[i+1] == 'u' {
+			r, width := decodeJSONUnicode(s[i:])
+			for _, c := range utf8.AppendRune(nil, r) {
+				if c < utf8.RuneSelf {
+					escaped = append(escaped, copyEscape([]byte{c})...)
+				} else {
+					escaped = append(escaped, '\\', 'x', hexDigits[c>>4], hexDigits[c&0xf])
+				}
+			}
+			i += width - 1
+			continue
+		}
+
+		end := i + 1
+		if s[i] == '\\' && end < len(s) {
+			end++
+		}
+		escaped = append(escaped, copyEscape(decodeJSONString(s[i:end]))...)
+		i = end - 1
+	}
+	return escaped
+}
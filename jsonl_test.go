@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFixJSONLRecord(t *testing.T) {
+	var tests = []struct {
+		testName string
+		keys     string
+		in       string
+		out      string
+		invalid  int64
+	}{
+		{
+			testName: "serialized value",
+			in:       `{"id":7,"option_value":"a:1:{s:3:\"url\";s:19:\"https://example.com\";}","home":"https://example.com"}` + "\n",
+			out:      `{"id":7,"option_value":"a:1:{s:3:\"url\";s:23:\"https://example.org/new\";}","home":"https://example.org/new"}` + "\n",
+		},
+		{
+			testName: "only the given keys",
+			keys:     "option_value",
+			in:       `{"id":7,"option_value":"a:1:{s:3:\"url\";s:19:\"https://example.com\";}","home":"https://example.com"}` + "\n",
+			out:      `{"id":7,"option_value":"a:1:{s:3:\"url\";s:23:\"https://example.org/new\";}","home":"https://example.com"}` + "\n",
+		},
+		{
+			testName: "escapes are counted decoded and kept",
+			in:       `{"v": "a:1:{i:0;s:21:\"éhttps:\/\/example.com\";}"}`,
+			out:      `{"v": "a:1:{i:0;s:25:\"éhttps:\/\/example.org\/new\";}"}`,
+		},
+		{
+			testName: "nested values belong to the top level key",
+			keys:     "meta, id",
+			in:       `{"meta":{"url":"https://example.com","list":["https://example.com"]},"home":"https://example.com"}`,
+			out:      `{"meta":{"url":"https://example.org/new","list":["https://example.org/new"]},"home":"https://example.com"}`,
+		},
+		{
+			testName: "keys are kept",
+			in:       `{"https://example.com":"https://example.com"}`,
+			out:      `{"https://example.com":"https://example.org/new"}`,
+		},
+		{
+			testName: "not a JSON object",
+			in:       "https://example.com\n",
+			out:      "https://example.com\n",
+			invalid:  1,
+		},
+		{
+			testName: "blank line",
+			in:       "\n",
+			out:      "\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			before := stats.invalidRecords.Load()
+
+			in := []byte(test.in)
+			replaced := newJSONLFormat(test.keys).fixRecord(&in, []*Replacement{
+				{
+					From: []byte("https://example.com"),
+					To:   []byte("https://example.org/new"),
+				},
+			}, noneEscaping)
+
+			if !bytes.Equal(*replaced, []byte(test.out)) {
+				t.Error("Expected:", test.out, "Actual:", string(*replaced))
+			}
+
+			if invalid := stats.invalidRecords.Load() - before; invalid != test.invalid {
+				t.Error("Expected:", test.invalid, "invalid records, Actual:", invalid)
+			}
+		})
+	}
+}
@@ -2,6 +2,10 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
@@ -106,3 +110,381 @@ func TestSerializedReplaceWithCssAndUnrelatedSerializationMarker(t *testing.T) {
 	expected := `a:2:{s:3:\"key\";s:5:\"value\";s:3:\"css\";s:237:\"body { color: #123456;\r\nborder-bottom: none; }\r\nbody:after{ content: \"▼\"; }\r\ndiv.bg { background: url('https://ncc-1701-d.space/wp-content/uploads/main-bg.gif');\r\n  background-position: left center;\r\n    background-repeat: no-repeat; }\";}`
 	doMainTest(t, input, expected, mainArgs)
 }
+
+func TestIgnoreCaseReplace(t *testing.T) {
+	mainArgs := []string{
+		"-ignore-case",
+		"http://uss-enterprise.com",
+		"https://ncc-1701-d.space",
+	}
+
+	input := "Check out: HTTP://USS-Enterprise.com/decks/10\n('s:32:\\\"http://USS-ENTERPRISE.com/bridge\\\";')\n"
+	expected := "Check out: https://ncc-1701-d.space/decks/10\n('s:31:\\\"https://ncc-1701-d.space/bridge\\\";')\n"
+	doMainTest(t, input, expected, mainArgs)
+}
+
+func TestRegexReplace(t *testing.T) {
+	mainArgs := []string{
+		"-regex",
+		`https?://cdn(\d+)\.uss-enterprise\.com`,
+		"https://static$1.ncc-1701-d.space",
+	}
+
+	input := "('http://cdn7.uss-enterprise.com/a.png','s:34:\\\"https://cdn12.uss-enterprise.com/b\\\";')\n"
+	expected := "('https://static7.ncc-1701-d.space/a.png','s:35:\\\"https://static12.ncc-1701-d.space/b\\\";')\n"
+	doMainTest(t, input, expected, mainArgs)
+}
+
+func TestInternationalizedDomainReplace(t *testing.T) {
+	mainArgs := []string{
+		"https://müller.de",
+		"https://example.com",
+	}
+
+	input := "('https://müller.de/','s:25:\\\"https://xn--mller-kva.de/\\\";')\n"
+	expected := "('https://example.com/','s:20:\\\"https://example.com/\\\";')\n"
+	doMainTest(t, input, expected, mainArgs)
+}
+
+func TestRelaxedPolicyReplace(t *testing.T) {
+	mainArgs := []string{
+		"-policy", "relaxed",
+		"http://uss-enterprise.com/?page_id=1701",
+		"https://ncc-1701-d.space/bridge",
+	}
+
+	input := "('http://uss-enterprise.com/?page_id=1701')\n"
+	expected := "('https://ncc-1701-d.space/bridge')\n"
+	doMainTest(t, input, expected, mainArgs)
+}
+
+func TestAllowEmptyReplace(t *testing.T) {
+	mainArgs := []string{
+		"-allow-empty",
+		"https://uss-enterprise.com/decks",
+		"",
+	}
+
+	input := "('s:35:\\\"https://uss-enterprise.com/decks/10\\\";','https://uss-enterprise.com/decks')\n"
+	expected := "('s:3:\\\"/10\\\";','')\n"
+	doMainTest(t, input, expected, mainArgs)
+}
+
+func TestJSONAwareReplace(t *testing.T) {
+	mainArgs := []string{
+		"-json",
+		"https://uss-enterprise.com",
+		"https://ncc-1701-d.space",
+	}
+
+	input := "('{\\\"url\\\":\\\"https:\\\\/\\\\/uss-enterprise.com\\\\/bridge\\\"}')\n"
+	expected := "('{\\\"url\\\":\\\"https:\\\\/\\\\/ncc-1701-d.space\\\\/bridge\\\"}')\n"
+	doMainTest(t, input, expected, mainArgs)
+}
+
+func TestPostgresDialectReplace(t *testing.T) {
+	mainArgs := []string{
+		"-dialect",
+		"postgres",
+		"https://uss-enterprise.com",
+		"https://ncc-1701-d.space",
+	}
+
+	input := "COPY public.wp_options (option_id, option_value) FROM stdin;\n" +
+		"1\ta:1:{i:0;s:26:\"https://uss-enterprise.com\";}\n" +
+		"\\.\n" +
+		"INSERT INTO public.wp_options VALUES (2, 'a:1:{i:0;s:31:\"it''s\nhttps://uss-enterprise.com\";}');\n"
+	expected := "COPY public.wp_options (option_id, option_value) FROM stdin;\n" +
+		"1\ta:1:{i:0;s:24:\"https://ncc-1701-d.space\";}\n" +
+		"\\.\n" +
+		"INSERT INTO public.wp_options VALUES (2, 'a:1:{i:0;s:29:\"it''s\nhttps://ncc-1701-d.space\";}');\n"
+	doMainTest(t, input, expected, mainArgs)
+}
+
+func TestSQLiteDialectDetected(t *testing.T) {
+	mainArgs := []string{
+		"https://uss-enterprise.com",
+		"https://ncc-1701-d.space",
+	}
+
+	input := "PRAGMA foreign_keys=OFF;\n" +
+		"BEGIN TRANSACTION;\n" +
+		"INSERT INTO wp_options VALUES(1,'a:1:{i:0;s:31:\"it''s https://uss-enterprise.com\";}');\n" +
+		"COMMIT;\n"
+	expected := "PRAGMA foreign_keys=OFF;\n" +
+		"BEGIN TRANSACTION;\n" +
+		"INSERT INTO wp_options VALUES(1,'a:1:{i:0;s:29:\"it''s https://ncc-1701-d.space\";}');\n" +
+		"COMMIT;\n"
+	doMainTest(t, input, expected, mainArgs)
+}
+
+func TestCSVFormatReplace(t *testing.T) {
+	mainArgs := []string{
+		"-format",
+		"csv",
+		"https://uss-enterprise.com",
+		"https://ncc-1701-d.space",
+	}
+
+	input := "option_id,option_value\n" +
+		"1,\"a:1:{i:0;s:32:\"\"ready\nhttps://uss-enterprise.com\"\";}\"\n"
+	expected := "option_id,option_value\n" +
+		"1,\"a:1:{i:0;s:30:\"\"ready\nhttps://ncc-1701-d.space\"\";}\"\n"
+	doMainTest(t, input, expected, mainArgs)
+}
+
+func TestWXRFormatReplace(t *testing.T) {
+	mainArgs := []string{
+		"-format",
+		"wxr",
+		"https://uss-enterprise.com",
+		"https://ncc-1701-d.space",
+	}
+
+	input := "<item>\n" +
+		"\t<link>https://uss-enterprise.com/bridge</link>\n" +
+		"\t<wp:postmeta>\n" +
+		"\t\t<wp:meta_value><![CDATA[a:1:{i:0;s:26:\"https://uss-enterprise.com\";}]]></wp:meta_value>\n" +
+		"\t</wp:postmeta>\n" +
+		"</item>\n"
+	expected := "<item>\n" +
+		"\t<link>https://ncc-1701-d.space/bridge</link>\n" +
+		"\t<wp:postmeta>\n" +
+		"\t\t<wp:meta_value><![CDATA[a:1:{i:0;s:24:\"https://ncc-1701-d.space\";}]]></wp:meta_value>\n" +
+		"\t</wp:postmeta>\n" +
+		"</item>\n"
+	doMainTest(t, input, expected, mainArgs)
+}
+
+func TestJSONLFormatReplace(t *testing.T) {
+	mainArgs := []string{
+		"-format",
+		"jsonl",
+		"-keys",
+		"option_value",
+		"https://uss-enterprise.com",
+		"https://ncc-1701-d.space",
+	}
+
+	input := `{"option_name":"https://uss-enterprise.com","option_value":"a:1:{i:0;s:26:\"https://uss-enterprise.com\";}"}` + "\n"
+	expected := `{"option_name":"https://uss-enterprise.com","option_value":"a:1:{i:0;s:24:\"https://ncc-1701-d.space\";}"}` + "\n"
+	doMainTest(t, input, expected, mainArgs)
+}
+
+func TestUnescapedInputReplace(t *testing.T) {
+	mainArgs := []string{
+		"-escaping",
+		"none",
+		"https://uss-enterprise.com",
+		"https://ncc-1701-d.space",
+	}
+
+	input := "a:2:{i:0;s:27:\"\nhttps://uss-enterprise.com\";i:1;s:4:\"C:\\n\";}\n"
+	expected := "a:2:{i:0;s:25:\"\nhttps://ncc-1701-d.space\";i:1;s:4:\"C:\\n\";}\n"
+	doMainTest(t, input, expected, mainArgs)
+}
+
+func TestUndoRulesRoundTrip(t *testing.T) {
+	undo := filepath.Join(t.TempDir(), "undo.txt")
+
+	original := "('s:26:\\\"https://uss-enterprise.com\\\";','https://uss-enterprise.com/decks/10')\n"
+	replaced := "('s:24:\\\"https://ncc-1701-d.space\\\";','https://ncc-1701-d.space/decks/10')\n"
+
+	doMainTest(t, original, replaced, []string{
+		"-undo",
+		undo,
+		"https://uss-enterprise.com",
+		"https://ncc-1701-d.space",
+	})
+
+	doMainTest(t, replaced, original, []string{
+		"-rules",
+		undo,
+	})
+}
+
+func TestStrictExistingTarget(t *testing.T) {
+	cmd := exec.Command("go", "run", basePath, "-strict", "http://uss-enterprise.com", "https://uss-enterprise.com")
+	cmd.Stdin = strings.NewReader("('http://uss-enterprise.com','https://uss-enterprise.com')\n")
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	// go run reports the exit status of the program on stderr
+	err := cmd.Run()
+	if _, ok := err.(*exec.ExitError); !ok || !strings.Contains(stderr.String(), "exit status 4") {
+		t.Errorf("Expected exit status 4, got %v: %s", err, stderr.String())
+	}
+
+	expected := "('https://uss-enterprise.com','https://uss-enterprise.com')\n"
+	if out.String() != expected {
+		t.Errorf("%v does not match expected: %v", out.String(), expected)
+	}
+
+	if !strings.Contains(stderr.String(), `"https://uss-enterprise.com" already occurred 1 times`) {
+		t.Errorf("Expected a warning, got: %s", stderr.String())
+	}
+}
+
+func TestStrictTargetInsideSource(t *testing.T) {
+	cmd := exec.Command("go", "run", basePath, "-strict", "https://uss-enterprise.com/bridge", "https://uss-enterprise.com")
+	cmd.Stdin = strings.NewReader("('https://uss-enterprise.com/bridge')\n")
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		t.Errorf("Expected success, got %v: %s", err, stderr.String())
+	}
+
+	expected := "('https://uss-enterprise.com')\n"
+	if out.String() != expected {
+		t.Errorf("%v does not match expected: %v", out.String(), expected)
+	}
+}
+
+func TestVerifyReplace(t *testing.T) {
+	mainArgs := []string{
+		"-verify",
+		"https://uss-enterprise.com",
+		"https://ncc-1701-d.space",
+	}
+
+	input := "('a:2:{i:0;s:26:\\\"https://uss-enterprise.com\\\";s:4:\\\"deck\\\";i:10;}','https://uss-enterprise.com')\n"
+	expected := "('a:2:{i:0;s:24:\\\"https://ncc-1701-d.space\\\";s:4:\\\"deck\\\";i:10;}','https://ncc-1701-d.space')\n"
+	doMainTest(t, input, expected, mainArgs)
+}
+
+func TestManifestChecksums(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "manifest.json")
+
+	input := "Space, the final frontier! https://ncc-1701-d.space\nCheck out: http://uss-enterprise.com/decks/10/sections/forward\n"
+	expected := "Space, the final frontier! https://ncc-1701-d.space\nCheck out: https://ncc-1701-d.space/decks/10/sections/forward\n"
+	doMainTest(t, input, expected, []string{
+		"-manifest",
+		path,
+		"http://uss-enterprise.com",
+		"https://ncc-1701-d.space",
+	})
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m manifest
+	if err := json.Unmarshal(contents, &m); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, stream := range []struct {
+		actual   manifestStream
+		expected string
+	}{{m.Input, input}, {m.Output, expected}} {
+		sum := sha256.Sum256([]byte(stream.expected))
+		if stream.actual.SHA256 != hex.EncodeToString(sum[:]) || stream.actual.Bytes != int64(len(stream.expected)) {
+			t.Errorf("%v does not match the checksum of %q", stream.actual, stream.expected)
+		}
+	}
+
+	// <to> values already in the input are counted without -strict too
+	if m.Stats.Lines != 2 || m.Stats.ChangedLines != 1 || len(m.Rules) != 1 || m.Rules[0].Existing != 1 {
+		t.Errorf("Unexpected manifest: %s", contents)
+	}
+}
+
+func TestValidateUTF8(t *testing.T) {
+	cmd := exec.Command("go", "run", basePath, "-validate-utf8", "https://uss-enterprise.com", "https://ncc-1701-d.space")
+	cmd.Stdin = strings.NewReader("('日本 https://uss-enterprise.com')\n('Caf\xe9 https://uss-enterprise.com')\n")
+	var out, stderr bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		t.Errorf("%v: %s", err, stderr.String())
+	}
+
+	expected := "('日本 https://ncc-1701-d.space')\n('Caf\xe9 https://ncc-1701-d.space')\n"
+	if out.String() != expected {
+		t.Errorf("%v does not match expected: %v", out.String(), expected)
+	}
+
+	report := "Invalid UTF-8 on line 2 at byte 6: \"('Caf\\xe9 https://uss-enterp\"\n"
+	if !strings.Contains(stderr.String(), report) {
+		t.Errorf("Expected %q on stderr, got: %s", report, stderr.String())
+	}
+}
+
+func TestCharsetConversionWithoutReplacing(t *testing.T) {
+	input := "('a:1:{i:0;s:7:\\\"na\xefve \xa9\\\";}','Caf\xe9')\n"
+	expected := "('a:1:{i:0;s:9:\\\"naïve ©\\\";}','Café')\n"
+	doMainTest(t, input, expected, []string{"-charset", "latin1"})
+}
+
+func TestCharsetConversionOfJSON(t *testing.T) {
+	var tests = []struct {
+		testName string
+		args     []string
+		input    string
+		expected string
+	}{
+		{
+			testName: "unchanged document",
+			args:     []string{"-json", "-charset", "latin1"},
+			input:    "('{\\\"caf\xe9\\\":1}','Caf\xe9')\n",
+			expected: "('{\\\"café\\\":1}','Café')\n",
+		},
+		{
+			testName: "keys of a changed document",
+			args:     []string{"-json", "-charset", "latin1", "http://uss-enterprise.com", "https://ncc-1701-d.space"},
+			input:    "('{\\\"caf\xe9\\\":\\\"http://uss-enterprise.com\\\"}')\n",
+			expected: "('{\\\"café\\\":\\\"https://ncc-1701-d.space\\\"}')\n",
+		},
+		{
+			testName: "jsonl keys and unselected values",
+			args:     []string{"-format", "jsonl", "-keys", "option_value", "-charset", "latin1"},
+			input:    "{\"caf\xe9\":\"Caf\xe9\",\"option_value\":\"na\xefve\"}\n{\"option_value\":1}\n",
+			expected: "{\"café\":\"Café\",\"option_value\":\"naïve\"}\n{\"option_value\":1}\n",
+		},
+		{
+			testName: "jsonl unicode escapes",
+			args:     []string{"-format", "jsonl", "-charset", "latin1"},
+			input:    "{\"a\":\"s:5:\\\"caf\\u00e9\\\";\",\"b\":\"s:4:\\\"caf\xe9\\\";\"}\n",
+			expected: "{\"a\":\"s:5:\\\"caf\\u00e9\\\";\",\"b\":\"s:5:\\\"caf\\u00e9\\\";\"}\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			doMainTest(t, test.input, test.expected, test.args)
+		})
+	}
+}
+
+func TestPostgresFunctionBodyWithApostrophe(t *testing.T) {
+	input := "-- PostgreSQL database dump\n" +
+		"CREATE FUNCTION public.greet() RETURNS text\n" +
+		"    LANGUAGE plpgsql\n" +
+		"    AS $$ BEGIN RETURN 'don''t'; -- don't\nEND; $$;\n" +
+		"COPY public.wp_options (option_value) FROM stdin;\n" +
+		"s:25:\"http://uss-enterprise.com\";\n" +
+		"\\.\n"
+	expected := strings.Replace(input, `s:25:"http://uss-enterprise.com"`, `s:24:"https://ncc-1701-d.space"`, 1)
+
+	doMainTest(t, input, expected, []string{
+		"http://uss-enterprise.com",
+		"https://ncc-1701-d.space",
+	})
+}
+
+func TestSQLiteLineBreakMarkerPerString(t *testing.T) {
+	input := "PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n" +
+		`INSERT INTO wp_options VALUES(1,replace('s:30:"line\nhttp://uss-enterprise.com";','\n',char(10)),'s:32:"C:\new\http://uss-enterprise.com";');` + "\n"
+	expected := "PRAGMA foreign_keys=OFF;\nBEGIN TRANSACTION;\n" +
+		`INSERT INTO wp_options VALUES(1,replace('s:29:"line\nhttps://ncc-1701-d.space";','\n',char(10)),'s:31:"C:\new\https://ncc-1701-d.space";');` + "\n"
+
+	doMainTest(t, input, expected, []string{
+		"-verify",
+		"http://uss-enterprise.com",
+		"https://ncc-1701-d.space",
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+)
+
+// digest hashes and counts the bytes written to it
+type digest struct {
+	hash  hash.Hash
+	bytes int64
+}
+
+func newDigest() *digest {
+	return &digest{hash: sha256.New()}
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	d.bytes += int64(len(p))
+	return d.hash.Write(p)
+}
+
+func (d *digest) stream() manifestStream {
+	return manifestStream{
+		SHA256: hex.EncodeToString(d.hash.Sum(nil)),
+		Bytes:  d.bytes,
+	}
+}
+
+// manifest records which input was turned into which output, and how
+type manifest struct {
+	Version string         `json:"version"`
+	Input   manifestStream `json:"input"`
+	Output  manifestStream `json:"output"`
+	Rules   []manifestRule `json:"rules"`
+	Stats   manifestStats  `json:"stats"`
+}
+
+type manifestStream struct {
+	SHA256 string `json:"sha256"`
+	Bytes  int64  `json:"bytes"`
+}
+
+type manifestRule struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	IgnoreCase bool   `json:"ignore_case,omitempty"`
+	Regex      bool   `json:"regex,omitempty"`
+
+	// Existing counts the occurrences of To in the input before replacing
+	Existing int64 `json:"existing,omitempty"`
+}
+
+type manifestStats struct {
+	Lines          int   `json:"lines"`
+	ChangedLines   int   `json:"changed_lines"`
+	VerifyFailures int   `json:"verify_failures,omitempty"`
+	Base64Payloads int64 `json:"base64_payloads,omitempty"`
+	InvalidRecords int64 `json:"invalid_records,omitempty"`
+	FailedLines    int64 `json:"failed_lines,omitempty"`
+	InvalidUTF8    int64 `json:"invalid_utf8_lines,omitempty"`
+}
+
+func newManifest(input, output *digest, replacements []*Replacement, lines, changed, verifyFailures int) *manifest {
+	m := &manifest{
+		Version: version,
+		Input:   input.stream(),
+		Output:  output.stream(),
+		Rules:   []manifestRule{},
+		Stats: manifestStats{
+			Lines:          lines,
+			ChangedLines:   changed,
+			VerifyFailures: verifyFailures,
+			Base64Payloads: stats.base64Payloads.Load(),
+			InvalidRecords: stats.invalidRecords.Load(),
+			FailedLines:    stats.failedUnits.Load(),
+			InvalidUTF8:    stats.invalidUTF8Units.Load(),
+		},
+	}
+
+	for _, replacement := range replacements {
+		m.Rules = append(m.Rules, manifestRule{
+			From:       string(replacement.From),
+			To:         string(replacement.To),
+			IgnoreCase: replacement.IgnoreCase,
+			Regex:      replacement.Pattern != nil,
+			Existing:   replacement.existing.Load(),
+		})
+	}
+
+	return m
+}
+
+func (m *manifest) write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(m)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDigest(t *testing.T) {
+	d := newDigest()
+	d.Write([]byte("Space, the final "))
+	d.Write([]byte("frontier!\n"))
+
+	expected := manifestStream{
+		SHA256: "18e02fa4073ce02d1f9abb64e8b4eb135507151c07142b77c0b46daf9b7f3bd0",
+		Bytes:  27,
+	}
+	if actual := d.stream(); actual != expected {
+		t.Error("Expected:", expected, "Actual:", actual)
+	}
+}
+
+func TestWriteManifest(t *testing.T) {
+	replacements := []*Replacement{
+		{From: []byte("http://uss-enterprise.com"), To: []byte("https://ncc-1701-d.space")},
+		{From: []byte("Enterprise"), To: []byte("Voyager"), IgnoreCase: true},
+	}
+	replacements[0].existing.Store(2)
+
+	m := &manifest{
+		Version: "1.2.3",
+		Input:   manifestStream{SHA256: "aa", Bytes: 10},
+		Output:  manifestStream{SHA256: "bb", Bytes: 9},
+		Rules:   newManifest(newDigest(), newDigest(), replacements, 0, 0, 0).Rules,
+		Stats:   manifestStats{Lines: 3, ChangedLines: 1},
+	}
+
+	expected := `{
+  "version": "1.2.3",
+  "input": {
+    "sha256": "aa",
+    "bytes": 10
+  },
+  "output": {
+    "sha256": "bb",
+    "bytes": 9
+  },
+  "rules": [
+    {
+      "from": "http://uss-enterprise.com",
+      "to": "https://ncc-1701-d.space",
+      "existing": 2
+    },
+    {
+      "from": "Enterprise",
+      "to": "Voyager",
+      "ignore_case": true
+    }
+  ],
+  "stats": {
+    "lines": 3,
+    "changed_lines": 1
+  }
+}
+`
+
+	var out bytes.Buffer
+	if err := m.write(&out); err != nil {
+		t.Error("Expected no error, Actual:", err)
+	}
+	if out.String() != expected {
+		t.Error("Expected:", expected, "Actual:", out.String())
+	}
+}
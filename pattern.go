@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+)
+
+// structuralChars are bytes that delimit or escape SQL values. A pattern that
+// could match any of them might rewrite the structure of the dump instead of
+// the data inside it.
+const structuralChars = "'\"`\\;(),\n\r\x00"
+
+// templateRefRe matches the references in a template the way Regexp.Expand
+// reads them: $name takes all the letters, digits and underscores that follow,
+// so $1x refers to a group named 1x
+var templateRefRe = regexp.MustCompile(`\$(\{\w+\}|\w+)`)
+
+// compilePattern compiles a regular expression rule and makes sure it can only
+// ever match data, never SQL structure.
+func compilePattern(expr string, ignoreCase bool) (*regexp.Regexp, error) {
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+
+	parsed, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkPatternSafety(parsed.Simplify()); err != nil {
+		return nil, err
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	if re.MatchString("") {
+		return nil, fmt.Errorf("pattern matches the empty string")
+	}
+
+	return re, nil
+}
+
+// checkTemplateRefs makes sure every reference in a template names a group of
+// the pattern. Expand replaces the others with nothing, which is easy to miss:
+// $1x is the group 1x, not $1 followed by x.
+func checkTemplateRefs(template string, pattern *regexp.Regexp) error {
+	for _, match := range templateRefRe.FindAllStringSubmatch(template, -1) {
+		name := strings.Trim(match[1], "{}")
+		if n, err := strconv.Atoi(name); err == nil && n <= pattern.NumSubexp() {
+			continue
+		}
+		if pattern.SubexpIndex(name) >= 0 {
+			continue
+		}
+
+		digits := len(name) - len(strings.TrimLeft(name, "0123456789"))
+		if digits > 0 && digits < len(name) && !strings.HasPrefix(match[0], "${") {
+			return fmt.Errorf("%s refers to a group named %q, which the pattern doesn't have; use ${%s}%s for group %s followed by %q",
+				match[0], name, name[:digits], name[digits:], name[:digits], name[digits:])
+		}
+		return fmt.Errorf("%s refers to a group the pattern doesn't have", match[0])
+	}
+	return nil
+}
+
+func checkPatternSafety(re *syntax.Regexp) error {
+	switch re.Op {
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return fmt.Errorf("%q can match SQL structural characters, use a character class such as [A-Za-z0-9.-] instead", re.String())
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if strings.ContainsRune(structuralChars, r) {
+				return fmt.Errorf("pattern contains the SQL structural character %q", r)
+			}
+		}
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			for _, c := range structuralChars {
+				if re.Rune[i] <= c && c <= re.Rune[i+1] {
+					return fmt.Errorf("character class %s can match the SQL structural character %q", re.String(), c)
+				}
+			}
+		}
+	}
+
+	for _, sub := range re.Sub {
+		if err := checkPatternSafety(sub); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
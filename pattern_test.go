@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+)
+
+func TestPatternReplace(t *testing.T) {
+	var tests = []struct {
+		testName string
+		in       []byte
+		out      []byte
+		pattern  string
+		to       []byte
+	}{
+		{
+			testName: "multisite upload path",
+
+			pattern: `/uploads/sites/\d+/`,
+			to:      []byte("/uploads/"),
+
+			in:  []byte(`('https://example.com/wp-content/uploads/sites/12/2020/cat.jpg')`),
+			out: []byte(`('https://example.com/wp-content/uploads/2020/cat.jpg')`),
+		},
+		{
+			testName: "capture group in serialized string",
+
+			pattern: `https?://cdn(\d+)\.example\.com`,
+			to:      []byte("https://static$1.example.org"),
+
+			in:  []byte(`s:29:\"http://cdn3.example.com/a.css\";`),
+			out: []byte(`s:33:\"https://static3.example.org/a.css\";`),
+		},
+		{
+			testName: "several matches in one serialized string",
+
+			pattern: `cdn\d+\.example\.com`,
+			to:      []byte("cdn.example.com"),
+
+			in:  []byte(`s:34:\"cdn1.example.com cdn22.example.com\";`),
+			out: []byte(`s:31:\"cdn.example.com cdn.example.com\";`),
+		},
+		{
+			testName: "match next to an escape sequence",
+
+			pattern: `[a-z]+\.com`,
+			to:      []byte("site.org"),
+
+			in:  []byte(`s:13:\"x\nexample.com\";`),
+			out: []byte(`s:10:\"x\nsite.org\";`),
+		},
+		{
+			testName: "match after an escaped backslash",
+
+			pattern: `[a-z]+\.com`,
+			to:      []byte("site.org"),
+
+			in:  []byte(`('C:\\example.com')`),
+			out: []byte(`('C:\\site.org')`),
+		},
+		{
+			testName: "named group",
+
+			pattern: `(?P<site>[a-z]+)\.example\.com`,
+			to:      []byte("example.com/${site}"),
+
+			in:  []byte(`('s:16:\"blog.example.com\";','shop.example.com')`),
+			out: []byte(`('s:16:\"example.com/blog\";','example.com/shop')`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			pattern, err := compilePattern(test.pattern, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			replaced := fixLine(&test.in, []*Replacement{
+				{
+					From:    []byte(test.pattern),
+					To:      test.to,
+					Pattern: pattern,
+				},
+			})
+
+			if !bytes.Equal(*replaced, test.out) {
+				t.Error("Expected:", string(test.out), "Actual:", string(*replaced))
+			}
+		})
+	}
+}
+
+func TestPatternSafety(t *testing.T) {
+	var tests = []struct {
+		testName string
+		pattern  string
+		valid    bool
+	}{
+		{
+			testName: "digits",
+			pattern:  `/sites/\d+/`,
+			valid:    true,
+		},
+		{
+			testName: "restricted negated class",
+			pattern:  `/sites/[^/'"\\;(),` + "`" + `\n\r\x00]+/`,
+			valid:    true,
+		},
+		{
+			testName: "any character",
+			pattern:  `example\.com/.*`,
+			valid:    false,
+		},
+		{
+			testName: "negated class",
+			pattern:  `example\.com/[^/]+`,
+			valid:    false,
+		},
+		{
+			testName: "quote literal",
+			pattern:  `example\.com'`,
+			valid:    false,
+		},
+		{
+			testName: "escaped semicolon",
+			pattern:  `example\.com\;`,
+			valid:    false,
+		},
+		{
+			testName: "whitespace class includes newline",
+			pattern:  `example\s+com`,
+			valid:    false,
+		},
+		{
+			testName: "matches empty string",
+			pattern:  `(example\.com)?`,
+			valid:    false,
+		},
+		{
+			testName: "syntax error",
+			pattern:  `example(`,
+			valid:    false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			_, err := compilePattern(test.pattern, false)
+			if (err == nil) != test.valid {
+				t.Error("Expected valid:", test.valid, "Actual error:", err)
+			}
+		})
+	}
+}
+
+func TestTemplateRefs(t *testing.T) {
+	var tests = []struct {
+		testName string
+		pattern  string
+		template string
+		err      string
+	}{
+		{
+			testName: "numbered group",
+			pattern:  `/sites/(\d+)/`,
+			template: "/s/$1/",
+		},
+		{
+			testName: "braced group followed by a letter",
+			pattern:  `/sites/(\d+)/`,
+			template: "/s/${1}x/",
+		},
+		{
+			testName: "named group",
+			pattern:  `/sites/(?P<site>\d+)/`,
+			template: "/s/$site/",
+		},
+		{
+			testName: "group followed by a letter",
+			pattern:  `/sites/(\d+)/`,
+			template: "/s/$1x/",
+			err:      `$1x refers to a group named "1x", which the pattern doesn't have; use ${1}x for group 1 followed by "x"`,
+		},
+		{
+			testName: "missing group",
+			pattern:  `/sites/(\d+)/`,
+			template: "/s/$2/",
+			err:      "$2 refers to a group the pattern doesn't have",
+		},
+		{
+			testName: "missing named group",
+			pattern:  `/sites/(\d+)/`,
+			template: "/s/${site}/",
+			err:      "${site} refers to a group the pattern doesn't have",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			err := checkTemplateRefs(test.template, regexp.MustCompile(test.pattern))
+			if err == nil && test.err != "" || err != nil && err.Error() != test.err {
+				t.Error("Expected:", test.err, "Actual:", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// phpValue is a parsed PHP serialized value. Unlike the line scanner in
+// fixLineWithSerializedData, it works on raw bytes: the data as PHP sees it,
+// without any SQL escaping.
+type phpValue struct {
+	// kind is the type letter: N, b, i, d, s, a, O, C, E, r or R
+	kind byte
+
+	// data holds the text of a scalar (the part between ':' and ';'), the
+	// contents of a string, the Class:Case name of an enum or the payload of a
+	// custom serialized (C:) object
+	data []byte
+
+	// class is the class name of an object
+	class []byte
+
+	// items holds the keys and values of an array, or the property names and
+	// values of an object, alternating
+	items []*phpValue
+}
+
+type phpParser struct {
+	data []byte
+	pos  int
+}
+
+// parseSerialized parses the PHP serialized value at the start of data and
+// returns it together with the number of bytes it took
+func parseSerialized(data []byte) (*phpValue, int, error) {
+	p := &phpParser{data: data}
+	v, err := p.value()
+	if err != nil {
+		return nil, p.pos, err
+	}
+	return v, p.pos, nil
+}
+
+// parseSerializedExactly parses data, which must be a single PHP serialized
+// value with nothing after it
+func parseSerializedExactly(data []byte) (*phpValue, error) {
+	v, n, err := parseSerialized(data)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(data) {
+		return nil, fmt.Errorf("unexpected data after serialized value at offset %d", n)
+	}
+	return v, nil
+}
+
+func (p *phpParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid serialized data at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *phpParser) expect(c byte) error {
+	if p.pos >= len(p.data) {
+		return p.errorf("expected %q, found end of data", c)
+	}
+	if p.data[p.pos] != c {
+		return p.errorf("expected %q, found %q", c, p.data[p.pos])
+	}
+	p.pos++
+	return nil
+}
+
+// until returns the bytes up to the next c and moves past c
+func (p *phpParser) until(c byte) ([]byte, error) {
+	start := p.pos
+	for p.pos < len(p.data) {
+		if p.data[p.pos] == c {
+			p.pos++
+			return p.data[start : p.pos-1], nil
+		}
+		p.pos++
+	}
+	return nil, p.errorf("expected %q, found end of data", c)
+}
+
+// length reads a non-negative decimal number terminated by ':'
+func (p *phpParser) length() (int, error) {
+	digits, err := p.until(':')
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := strconv.Atoi(string(digits))
+	if err != nil || n < 0 || digits[0] == '+' || digits[0] == '-' {
+		return 0, p.errorf("invalid length %q", digits)
+	}
+	return n, nil
+}
+
+func (p *phpParser) value() (*phpValue, error) {
+	if p.pos >= len(p.data) {
+		return nil, p.errorf("expected a value, found end of data")
+	}
+
+	v := &phpValue{kind: p.data[p.pos]}
+	p.pos++
+
+	switch v.kind {
+	case 'N':
+		return v, p.expect(';')
+
+	case 'b', 'i', 'd', 'r', 'R':
+		if err := p.expect(':'); err != nil {
+			return nil, err
+		}
+		data, err := p.until(';')
+		if err != nil {
+			return nil, err
+		}
+		if !validScalar(v.kind, data) {
+			return nil, p.errorf("invalid %c value %q", v.kind, data)
+		}
+		v.data = data
+		return v, nil
+
+	case 's':
+		if err := p.expect(':'); err != nil {
+			return nil, err
+		}
+		data, err := p.quoted()
+		if err != nil {
+			return nil, err
+		}
+		v.data = data
+		return v, p.expect(';')
+
+	case 'a':
+		if err := p.expect(':'); err != nil {
+			return nil, err
+		}
+		count, err := p.length()
+		if err != nil {
+			return nil, err
+		}
+		items, err := p.members(count)
+		if err != nil {
+			return nil, err
+		}
+		v.items = items
+		return v, nil
+
+	case 'O':
+		if err := p.className(v); err != nil {
+			return nil, err
+		}
+		count, err := p.length()
+		if err != nil {
+			return nil, err
+		}
+		items, err := p.members(count)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < len(items); i += 2 {
+			if err := validPropertyName(items[i], v.class); err != nil {
+				return nil, p.errorf("%s", err)
+			}
+		}
+		v.items = items
+		return v, nil
+
+	case 'C':
+		if err := p.className(v); err != nil {
+			return nil, err
+		}
+		n, err := p.length()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect('{'); err != nil {
+			return nil, err
+		}
+		if p.pos+n > len(p.data) {
+			return nil, p.errorf("payload of %d bytes runs past the end of data", n)
+		}
+		v.data = p.data[p.pos : p.pos+n]
+		p.pos += n
+		return v, p.expect('}')
+
+	case 'E':
+		if err := p.expect(':'); err != nil {
+			return nil, err
+		}
+		data, err := p.quoted()
+		if err != nil {
+			return nil, err
+		}
+		if bytes.IndexByte(data, ':') < 1 {
+			return nil, p.errorf("invalid enum %q", data)
+		}
+		v.data = data
+		return v, p.expect(';')
+	}
+
+	p.pos--
+	return nil, p.errorf("unknown type %q", v.kind)
+}
+
+// quoted reads a length-prefixed, double quoted string: 5:"hello"
+func (p *phpParser) quoted() ([]byte, error) {
+	n, err := p.length()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect('"'); err != nil {
+		return nil, err
+	}
+	if p.pos+n > len(p.data) {
+		return nil, p.errorf("string of %d bytes runs past the end of data", n)
+	}
+	data := p.data[p.pos : p.pos+n]
+	p.pos += n
+	return data, p.expect('"')
+}
+
+// className reads the :N:"Class": part of an object
+func (p *phpParser) className(v *phpValue) error {
+	if err := p.expect(':'); err != nil {
+		return err
+	}
+	class, err := p.quoted()
+	if err != nil {
+		return err
+	}
+	if !validClassName(class) {
+		return p.errorf("invalid class name %q", class)
+	}
+	v.class = class
+	return p.expect(':')
+}
+
+// validClassName checks a possibly namespaced PHP class name. As in PHP, any
+// byte from 0x80 up counts as a letter.
+func validClassName(class []byte) bool {
+	for _, part := range bytes.Split(class, []byte{'\\'}) {
+		if len(part) == 0 || '0' <= part[0] && part[0] <= '9' {
+			return false
+		}
+		for _, c := range part {
+			if !(c == '_' || c >= 0x80 || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validPropertyName checks the name of an object property. Private and
+// protected properties are prefixed with NUL-byte delimited markers:
+// "\0Class\0name" and "\0*\0name" respectively.
+func validPropertyName(key *phpValue, class []byte) error {
+	if key.kind == 'i' || len(key.data) == 0 || key.data[0] != 0 {
+		return nil
+	}
+
+	end := bytes.IndexByte(key.data[1:], 0)
+	if end < 0 {
+		return fmt.Errorf("property name %q has an unterminated visibility marker", key.data)
+	}
+
+	scope := key.data[1 : end+1]
+	if string(scope) != "*" && !validClassName(scope) {
+		return fmt.Errorf("property name %q has an invalid visibility marker", key.data)
+	}
+	return nil
+}
+
+// members reads count key/value pairs enclosed in braces
+func (p *phpParser) members(count int) ([]*phpValue, error) {
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+
+	var items []*phpValue
+	for i := 0; i < count; i++ {
+		if p.pos < len(p.data) && p.data[p.pos] != 'i' && p.data[p.pos] != 's' {
+			return nil, p.errorf("array keys must be integers or strings, found %q", p.data[p.pos])
+		}
+
+		key, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, key, value)
+	}
+
+	return items, p.expect('}')
+}
+
+func validScalar(kind byte, data []byte) bool {
+	switch kind {
+	case 'b':
+		return string(data) == "0" || string(data) == "1"
+	case 'i', 'r', 'R':
+		_, err := strconv.ParseInt(string(data), 10, 64)
+		return err == nil
+	case 'd':
+		switch string(data) {
+		case "INF", "-INF", "NAN":
+			return true
+		}
+		_, err := strconv.ParseFloat(string(data), 64)
+		return err == nil
+	}
+	return false
+}
+
+// serialize encodes the value again, with lengths computed from the data
+func (v *phpValue) serialize() []byte {
+	return v.appendTo(nil)
+}
+
+func (v *phpValue) appendTo(b []byte) []byte {
+	switch v.kind {
+	case 'N':
+		return append(b, 'N', ';')
+
+	case 's':
+		b = append(b, 's', ':')
+		b = strconv.AppendInt(b, int64(len(v.data)), 10)
+		b = append(b, ':', '"')
+		b = append(b, v.data...)
+		return append(b, '"', ';')
+
+	case 'a':
+		b = append(b, 'a', ':')
+		b = strconv.AppendInt(b, int64(len(v.items)/2), 10)
+		b = append(b, ':', '{')
+		for _, item := range v.items {
+			b = item.appendTo(b)
+		}
+		return append(b, '}')
+
+	case 'O':
+		b = v.appendClass(b)
+		b = strconv.AppendInt(b, int64(len(v.items)/2), 10)
+		b = append(b, ':', '{')
+		for _, item := range v.items {
+			b = item.appendTo(b)
+		}
+		return append(b, '}')
+
+	case 'C':
+		b = v.appendClass(b)
+		b = strconv.AppendInt(b, int64(len(v.data)), 10)
+		b = append(b, ':', '{')
+		b = append(b, v.data...)
+		return append(b, '}')
+
+	case 'E':
+		b = append(b, 'E', ':')
+		b = strconv.AppendInt(b, int64(len(v.data)), 10)
+		b = append(b, ':', '"')
+		b = append(b, v.data...)
+		return append(b, '"', ';')
+	}
+
+	b = append(b, v.kind, ':')
+	b = append(b, v.data...)
+	return append(b, ';')
+}
+
+func (v *phpValue) appendClass(b []byte) []byte {
+	b = append(b, v.kind, ':')
+	b = strconv.AppendInt(b, int64(len(v.class)), 10)
+	b = append(b, ':', '"')
+	b = append(b, v.class...)
+	return append(b, '"', ':')
+}
+
+// replaceStrings applies the replacements to every string in the value,
+// including array keys and property names, and reports whether anything
+// changed. Class names are left alone.
+func (v *phpValue) replaceStrings(replacements []*Replacement) bool {
+	changed := false
+
+	var replaced []byte
+	switch v.kind {
+	case 's':
+		replaced = replaceSerializedString(v.data, replacements)
+	case 'C':
+		replaced = replaceCustomPayload(v.data, replacements)
+	}
+
+	if replaced != nil && string(replaced) != string(v.data) {
+		v.data = replaced
+		changed = true
+	}
+
+	for _, item := range v.items {
+		if item.replaceStrings(replacements) {
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// replaceSerializedString applies the replacements to the contents of a
+// serialized string. Contents that are serialized data themselves, as
+// maybe_serialize() produces when it's applied twice, are rewritten so their
+// inner lengths are fixed; the caller recomputes the outer length.
+func replaceSerializedString(data []byte, replacements []*Replacement) []byte {
+	if nested, ok := rewriteNested(data, replacements); ok {
+		return nested
+	}
+	return replacePlain(data, replacements, noneEscaping)
+}
+
+// rewriteNested rewrites data if it is serialized data, either as it is or
+// escaped with addslashes() before being serialized again
+func rewriteNested(data []byte, replacements []*Replacement) ([]byte, bool) {
+	if !isSerializedStart(data) {
+		return nil, false
+	}
+
+	if value, err := parseSerializedExactly(data); err == nil {
+		if !value.replaceStrings(replacements) {
+			return data, true
+		}
+		return value.serialize(), true
+	}
+
+	// only take the addslashes() route if it round trips, so nothing but the
+	// replaced strings can change
+	unslashed := stripslashes(data)
+	if !bytes.Equal(addslashes(unslashed), data) {
+		return nil, false
+	}
+
+	value, err := parseSerializedExactly(unslashed)
+	if err != nil {
+		return nil, false
+	}
+	if !value.replaceStrings(replacements) {
+		return data, true
+	}
+	return addslashes(value.serialize()), true
+}
+
+// replaceCustomPayload applies the replacements to the payload of a custom
+// serialized (C:) object. The payload is whatever the class's serialize()
+// method returned; most classes, such as ArrayObject with its
+// "x:i:0;a:0:{};m:a:0:{}", embed serialized values in it. Those values are
+// rewritten and the text around them is kept. A payload without any embedded
+// values is treated as plain text.
+func replaceCustomPayload(payload []byte, replacements []*Replacement) []byte {
+	var rebuilt []byte
+	found := false
+
+	for i := 0; i < len(payload); {
+		if isSerializedStart(payload[i:]) {
+			if value, n, err := parseSerialized(payload[i:]); err == nil {
+				value.replaceStrings(replacements)
+				rebuilt = value.appendTo(rebuilt)
+				found = true
+				i += n
+				continue
+			}
+		}
+		rebuilt = append(rebuilt, payload[i])
+		i++
+	}
+
+	if !found {
+		return replacePlain(payload, replacements, noneEscaping)
+	}
+	return rebuilt
+}
+
+// stripslashes works like PHP's stripslashes()
+func stripslashes(data []byte) []byte {
+	unslashed := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		if data[i] != '\\' {
+			unslashed = append(unslashed, data[i])
+			continue
+		}
+
+		i++
+		if i == len(data) {
+			break
+		}
+		if data[i] == '0' {
+			unslashed = append(unslashed, 0)
+			continue
+		}
+		unslashed = append(unslashed, data[i])
+	}
+	return unslashed
+}
+
+// addslashes works like PHP's addslashes()
+func addslashes(data []byte) []byte {
+	slashed := make([]byte, 0, len(data)+len(data)/8)
+	for _, c := range data {
+		switch c {
+		case '\'', '"', '\\':
+			slashed = append(slashed, '\\', c)
+		case 0:
+			slashed = append(slashed, '\\', '0')
+		default:
+			slashed = append(slashed, c)
+		}
+	}
+	return slashed
+}
+
+// isSerializedStart reports whether data could start a PHP serialized value
+func isSerializedStart(data []byte) bool {
+	if len(data) < 2 {
+		return false
+	}
+
+	switch data[0] {
+	case 'N':
+		return data[1] == ';'
+	case 'b', 'i', 'd', 's', 'a', 'O', 'C', 'E':
+		return data[1] == ':'
+	}
+	return false
+}
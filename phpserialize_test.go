@@ -0,0 +1,162 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseSerialized(t *testing.T) {
+	var tests = []struct {
+		testName string
+		in       string
+		valid    bool
+	}{
+		{
+			testName: "scalars",
+			in:       `a:6:{i:0;N;i:1;b:1;i:2;i:-42;i:3;d:0.5;i:4;d:INF;i:5;s:0:"";}`,
+			valid:    true,
+		},
+		{
+			testName: "nested arrays with string keys",
+			in:       `a:2:{s:4:"home";s:19:"https://example.com";s:5:"links";a:1:{i:0;s:1:";";}}`,
+			valid:    true,
+		},
+		{
+			testName: "multibyte string",
+			in:       `s:6:"müll";`,
+			valid:    false,
+		},
+		{
+			testName: "multibyte string with byte length",
+			in:       `s:5:"müll";`,
+			valid:    true,
+		},
+		{
+			testName: "wrong string length",
+			in:       `s:5:"hello world";`,
+			valid:    false,
+		},
+		{
+			testName: "string length past the end",
+			in:       `s:50:"hello";`,
+			valid:    false,
+		},
+		{
+			testName: "wrong array count",
+			in:       `a:2:{i:0;s:1:"a";}`,
+			valid:    false,
+		},
+		{
+			testName: "array key must be scalar",
+			in:       `a:1:{a:0:{}i:0;}`,
+			valid:    false,
+		},
+		{
+			testName: "negative length",
+			in:       `s:-1:"";`,
+			valid:    false,
+		},
+		{
+			testName: "trailing data",
+			in:       `b:0;b:1;`,
+			valid:    false,
+		},
+		{
+			testName: "object with private and protected properties",
+			in:       "O:8:\"stdClass\":3:{s:4:\"\x00*\x00a\";i:1;s:11:\"\x00stdClass\x00b\";N;s:1:\"c\";b:0;}",
+			valid:    true,
+		},
+		{
+			testName: "namespaced object",
+			in:       `O:9:"App\Model":1:{i:0;s:1:"x";}`,
+			valid:    true,
+		},
+		{
+			testName: "object class length mismatch",
+			in:       `O:9:"stdClass":0:{}`,
+			valid:    false,
+		},
+		{
+			testName: "object with a broken visibility marker",
+			in:       "O:8:\"stdClass\":1:{s:3:\"\x00*a\";i:1;}",
+			valid:    false,
+		},
+		{
+			testName: "custom serialized object",
+			in:       `C:11:"ArrayObject":21:{x:i:0;a:0:{};m:a:0:{}}`,
+			valid:    true,
+		},
+		{
+			testName: "custom serialized payload length mismatch",
+			in:       `C:11:"ArrayObject":20:{x:i:0;a:0:{};m:a:0:{}}`,
+			valid:    false,
+		},
+		{
+			testName: "enum",
+			in:       `E:11:"Suit:Hearts";`,
+			valid:    true,
+		},
+		{
+			testName: "bad boolean",
+			in:       `b:2;`,
+			valid:    false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			value, err := parseSerializedExactly([]byte(test.in))
+			if (err == nil) != test.valid {
+				t.Fatal("Expected valid:", test.valid, "Actual error:", err)
+			}
+
+			if err == nil && string(value.serialize()) != test.in {
+				t.Error("Expected:", test.in, "Actual:", string(value.serialize()))
+			}
+		})
+	}
+}
+
+func TestSerializedReplaceStrings(t *testing.T) {
+	in := `a:2:{s:19:"https://example.com";s:25:"https://example.com/about";i:0;s:4:"none";}`
+	out := `a:2:{s:21:"https://example.org/x";s:27:"https://example.org/x/about";i:0;s:4:"none";}`
+
+	value, err := parseSerializedExactly([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed := value.replaceStrings([]*Replacement{
+		{
+			From: []byte("https://example.com"),
+			To:   []byte("https://example.org/x"),
+		},
+	})
+
+	if !changed {
+		t.Error("Expected a change")
+	}
+	if string(value.serialize()) != out {
+		t.Error("Expected:", out, "Actual:", string(value.serialize()))
+	}
+}
+
+func TestCustomPayloadReplace(t *testing.T) {
+	in := `C:11:"ArrayObject":58:{x:i:0;a:1:{s:3:"url";s:19:"https://example.com";};m:a:0:{}}`
+	out := `C:11:"ArrayObject":62:{x:i:0;a:1:{s:3:"url";s:23:"https://example.org/new";};m:a:0:{}}`
+
+	value, err := parseSerializedExactly([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value.replaceStrings([]*Replacement{
+		{
+			From: []byte("https://example.com"),
+			To:   []byte("https://example.org/new"),
+		},
+	})
+
+	if string(value.serialize()) != out {
+		t.Error("Expected:", out, "Actual:", string(value.serialize()))
+	}
+}
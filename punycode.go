@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Punycode parameters from RFC 3492, section 5
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+
+	acePrefix = "xn--"
+)
+
+func punyAdapt(delta, numPoints int, firstTime bool) int {
+	if firstTime {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
+
+func punyThreshold(k, bias int) int {
+	t := k - bias
+	if t < punyTMin {
+		return punyTMin
+	}
+	if t > punyTMax {
+		return punyTMax
+	}
+	return t
+}
+
+func punyEncodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punyDecodeDigit(c byte) (int, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return int(c-'0') + 26, true
+	case 'a' <= c && c <= 'z':
+		return int(c - 'a'), true
+	case 'A' <= c && c <= 'Z':
+		return int(c - 'A'), true
+	}
+	return 0, false
+}
+
+// punycodeEncode encodes a single label, without the "xn--" prefix
+func punycodeEncode(label string) string {
+	runes := []rune(label)
+	var output []byte
+
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			output = append(output, byte(r))
+		}
+	}
+
+	basicCount := len(output)
+	handled := basicCount
+	if basicCount > 0 {
+		output = append(output, '-')
+	}
+
+	n := punyInitialN
+	delta := 0
+	bias := punyInitialBias
+
+	for handled < len(runes) {
+		m := int(utf8.MaxRune) + 1
+		for _, r := range runes {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+
+		delta += (m - n) * (handled + 1)
+		n = m
+
+		for _, r := range runes {
+			if int(r) < n {
+				delta++
+			}
+			if int(r) != n {
+				continue
+			}
+
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := punyThreshold(k, bias)
+				if q < t {
+					break
+				}
+				output = append(output, punyEncodeDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			output = append(output, punyEncodeDigit(q))
+
+			bias = punyAdapt(delta, handled+1, handled == basicCount)
+			delta = 0
+			handled++
+		}
+
+		delta++
+		n++
+	}
+
+	return string(output)
+}
+
+// punycodeDecode decodes a single label, without the "xn--" prefix
+func punycodeDecode(encoded string) (string, error) {
+	var output []rune
+	pos := 0
+
+	if b := strings.LastIndexByte(encoded, '-'); b >= 0 {
+		for i := 0; i < b; i++ {
+			if encoded[i] >= utf8.RuneSelf {
+				return "", fmt.Errorf("invalid punycode %q: non-ASCII basic code point", encoded)
+			}
+			output = append(output, rune(encoded[i]))
+		}
+		pos = b + 1
+	}
+
+	n := punyInitialN
+	i := 0
+	bias := punyInitialBias
+
+	for pos < len(encoded) {
+		oldI := i
+		w := 1
+		for k := punyBase; ; k += punyBase {
+			if pos >= len(encoded) {
+				return "", fmt.Errorf("invalid punycode %q: truncated input", encoded)
+			}
+
+			digit, ok := punyDecodeDigit(encoded[pos])
+			pos++
+			if !ok {
+				return "", fmt.Errorf("invalid punycode %q: bad digit", encoded)
+			}
+
+			i += digit * w
+			if i > utf8.MaxRune*(len(output)+1) {
+				return "", fmt.Errorf("invalid punycode %q: overflow", encoded)
+			}
+
+			t := punyThreshold(k, bias)
+			if digit < t {
+				break
+			}
+			w *= punyBase - t
+		}
+
+		bias = punyAdapt(i-oldI, len(output)+1, oldI == 0)
+		n += i / (len(output) + 1)
+		i %= len(output) + 1
+
+		if n > utf8.MaxRune {
+			return "", fmt.Errorf("invalid punycode %q: code point out of range", encoded)
+		}
+
+		output = append(output, 0)
+		copy(output[i+1:], output[i:])
+		output[i] = rune(n)
+		i++
+	}
+
+	return string(output), nil
+}
+
+// hostBounds returns the position of the host name in a URL-ish value such as
+// "https://example.com/path", "//example.com" or "example.com:8080"
+func hostBounds(s string) (int, int) {
+	start := 0
+	if i := strings.Index(s, "://"); i >= 0 {
+		start = i + 3
+	} else if strings.HasPrefix(s, "//") {
+		start = 2
+	}
+
+	end := strings.IndexAny(s[start:], "/:")
+	if end < 0 {
+		return start, len(s)
+	}
+
+	return start, start + end
+}
+
+// idnForms returns the Unicode and the ASCII (punycode) form of the host
+// inside s. ok is false when the host is not an internationalized domain name.
+func idnForms(s string) (unicodeForm, asciiForm string, ok bool) {
+	start, end := hostBounds(s)
+	labels := strings.Split(s[start:end], ".")
+	unicodeLabels := make([]string, len(labels))
+	asciiLabels := make([]string, len(labels))
+
+	for i, label := range labels {
+		unicodeLabels[i] = label
+		asciiLabels[i] = label
+
+		if hasNonASCII(label) {
+			asciiLabels[i] = acePrefix + punycodeEncode(strings.ToLower(label))
+			ok = true
+			continue
+		}
+
+		if len(label) > len(acePrefix) && strings.EqualFold(label[:len(acePrefix)], acePrefix) {
+			decoded, err := punycodeDecode(label[len(acePrefix):])
+			if err != nil {
+				continue
+			}
+			unicodeLabels[i] = decoded
+			ok = true
+		}
+	}
+
+	if !ok {
+		return s, s, false
+	}
+
+	unicodeForm = s[:start] + strings.Join(unicodeLabels, ".") + s[end:]
+	asciiForm = s[:start] + strings.Join(asciiLabels, ".") + s[end:]
+
+	return unicodeForm, asciiForm, true
+}
+
+// idnVariants expands a from/to pair so that both the Unicode and the
+// punycode spelling of an internationalized <from> host get replaced. If <to>
+// is internationalized as well, each spelling is replaced by the same spelling
+// of <to>.
+func idnVariants(from, to string) [][2]string {
+	unicodeFrom, asciiFrom, ok := idnForms(from)
+	if !ok {
+		return [][2]string{{from, to}}
+	}
+
+	unicodeTo, asciiTo, toIsIDN := idnForms(to)
+	if !toIsIDN {
+		unicodeTo, asciiTo = to, to
+	}
+
+	return [][2]string{
+		{unicodeFrom, unicodeTo},
+		{asciiFrom, asciiTo},
+	}
+}
+
+func hasNonASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPunycode(t *testing.T) {
+	var tests = []struct {
+		testName string
+		decoded  string
+		encoded  string
+	}{
+		{
+			testName: "single umlaut",
+			decoded:  "müller",
+			encoded:  "mller-kva",
+		},
+		{
+			testName: "umlaut in the middle",
+			decoded:  "münchen",
+			encoded:  "mnchen-3ya",
+		},
+		{
+			testName: "RFC 3492 Arabic (Egyptian)",
+			decoded:  "ليهمابتكلموشعربي؟",
+			encoded:  "egbpdaj6bu4bxfgehfvwxn",
+		},
+		{
+			testName: "RFC 3492 Chinese (simplified)",
+			decoded:  "他们为什么不说中文",
+			encoded:  "ihqwcrb4cv8a8dqg056pqjye",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			encoded := punycodeEncode(test.decoded)
+			if encoded != test.encoded {
+				t.Error("Expected:", test.encoded, "Actual:", encoded)
+			}
+
+			decoded, err := punycodeDecode(test.encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded != test.decoded {
+				t.Error("Expected:", test.decoded, "Actual:", decoded)
+			}
+		})
+	}
+}
+
+func TestIDNVariants(t *testing.T) {
+	var tests = []struct {
+		testName string
+		from     string
+		to       string
+		variants [][2]string
+	}{
+		{
+			testName: "ASCII host",
+			from:     "https://example.com",
+			to:       "https://example.org",
+			variants: [][2]string{{"https://example.com", "https://example.org"}},
+		},
+		{
+			testName: "Unicode from",
+			from:     "https://müller.de/shop",
+			to:       "https://example.com/shop",
+			variants: [][2]string{
+				{"https://müller.de/shop", "https://example.com/shop"},
+				{"https://xn--mller-kva.de/shop", "https://example.com/shop"},
+			},
+		},
+		{
+			testName: "punycode from",
+			from:     "xn--mller-kva.de",
+			to:       "example.com",
+			variants: [][2]string{
+				{"müller.de", "example.com"},
+				{"xn--mller-kva.de", "example.com"},
+			},
+		},
+		{
+			testName: "Unicode from and to",
+			from:     "//müller.de",
+			to:       "//bücher.de",
+			variants: [][2]string{
+				{"//müller.de", "//bücher.de"},
+				{"//xn--mller-kva.de", "//xn--bcher-kva.de"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			variants := idnVariants(test.from, test.to)
+			if len(variants) != len(test.variants) {
+				t.Fatal("Expected:", test.variants, "Actual:", variants)
+			}
+			for i := range variants {
+				if variants[i] != test.variants[i] {
+					t.Error("Expected:", test.variants[i], "Actual:", variants[i])
+				}
+			}
+		})
+	}
+}
+
+func TestIDNReplace(t *testing.T) {
+	var replacements []*Replacement
+	for _, variant := range idnVariants("müller.de", "bücher.de") {
+		replacements = append(replacements, &Replacement{
+			From: []byte(variant[0]),
+			To:   []byte(variant[1]),
+		})
+	}
+
+	in := []byte(`('s:17:\"http://müller.de\";','http://xn--mller-kva.de','s:24:\"http://xn--mller-kva.de/\";')`)
+	out := []byte(`('s:17:\"http://bücher.de\";','http://xn--bcher-kva.de','s:24:\"http://xn--bcher-kva.de/\";')`)
+
+	replaced := fixLine(&in, replacements)
+	if !bytes.Equal(*replaced, out) {
+		t.Error("Expected:", string(out), "Actual:", string(*replaced))
+	}
+}
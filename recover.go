@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+)
+
+// excerptSize is how much of a line is shown when reporting it
+const excerptSize = 80
+
+// recoverUnit is deferred by the goroutine fixing a unit. A panic while fixing
+// it is recovered and the unit is sent on unchanged, with the panic as its
+// error, so that one odd line can't cut the output short.
+func recoverUnit(original []byte, ch chan<- fixedUnit) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	stats.failedUnits.Add(1)
+	ch <- fixedUnit{
+		original: original,
+		fixed:    original,
+		fixErr:   fmt.Errorf("%v", r),
+	}
+}
+
+// excerpt returns the start of a line, quoted, to identify it in messages
+func excerpt(line []byte) string {
+	if len(line) <= excerptSize {
+		return fmt.Sprintf("%q", line)
+	}
+	return fmt.Sprintf("%q…", line[:excerptSize])
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRecoverUnit(t *testing.T) {
+	failed := stats.failedUnits.Load()
+	defer stats.failedUnits.Store(failed)
+
+	original := []byte("('s:26:\\\"https://uss-enterprise.com\\\";')\n")
+
+	var tests = []struct {
+		testName string
+		panics   bool
+	}{
+		{
+			testName: "panic",
+			panics:   true,
+		},
+		{
+			testName: "no panic",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			ch := make(chan fixedUnit, 1)
+			func() {
+				defer recoverUnit(original, ch)
+				if test.panics {
+					var line []byte
+					_ = line[len(original)]
+				}
+				ch <- fixedUnit{original: original, fixed: []byte("fixed")}
+			}()
+
+			unit := <-ch
+			if !test.panics {
+				if unit.fixErr != nil || string(unit.fixed) != "fixed" {
+					t.Error("Expected the fixed unit, Actual:", unit)
+				}
+				return
+			}
+
+			if !bytes.Equal(unit.fixed, original) {
+				t.Error("Expected:", string(original), "Actual:", string(unit.fixed))
+			}
+			if unit.fixErr == nil || !strings.Contains(unit.fixErr.Error(), "index out of range") {
+				t.Error("Expected an index out of range error, Actual:", unit.fixErr)
+			}
+		})
+	}
+
+	if actual := stats.failedUnits.Load() - failed; actual != 1 {
+		t.Error("Expected:", 1, "Actual:", actual)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	var tests = []struct {
+		testName string
+		line     string
+		expected string
+	}{
+		{
+			testName: "short line",
+			line:     "('https://uss-enterprise.com')\n",
+			expected: `"('https://uss-enterprise.com')\n"`,
+		},
+		{
+			testName: "long line",
+			line:     strings.Repeat("0123456789", 10),
+			expected: `"` + strings.Repeat("0123456789", 8) + `"…`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			if actual := excerpt([]byte(test.line)); actual != test.expected {
+				t.Error("Expected:", test.expected, "Actual:", actual)
+			}
+		})
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"regexp"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -18,7 +19,7 @@ const (
 	replaceRe = `s:\d+:\\\"(.*?)\\\";`
 
 	badInputRe   = `\w:\d+:`
-	inputRe      = `^[A-Za-z0-9_\-\.:/]+$`
+	inputRe      = `^[\p{L}\p{M}\p{N}_\-\.:/]+$`
 	minInLength  = 4
 	minOutLength = 2
 
@@ -33,9 +34,21 @@ var (
 )
 
 // Replacement has two fields (both byte slices): "From" & "To"
+// IgnoreCase makes From match regardless of ASCII letter case.
+// When Pattern is set it is used instead of From, and To is expanded as a
+// template, so $1 refers to the first capture group.
 type Replacement struct {
-	From []byte
-	To   []byte
+	From       []byte
+	To         []byte
+	IgnoreCase bool
+	Pattern    *regexp.Regexp
+
+	// existing counts the occurrences of To in the input before replacing
+	existing atomic.Int64
+
+	// escapedMatches counts the matches left alone because they follow an
+	// escaping backslash
+	escapedMatches atomic.Int64
 }
 
 type SerializedReplaceResult struct {
@@ -44,8 +57,43 @@ type SerializedReplaceResult struct {
 	Post              []byte
 }
 
+// fixedUnit is a unit of the input before and after fixing it
+type fixedUnit struct {
+	original []byte
+	fixed    []byte
+
+	// verifyErr is why the fixed unit failed verification
+	verifyErr error
+
+	// fixErr is the panic that left the unit unchanged
+	fixErr error
+
+	// invalidUTF8 holds the offsets of invalid UTF-8 in the original unit
+	invalidUTF8 []int
+}
+
 func main() {
 	versionFlag := flag.Bool("version", false, "Show version information")
+	ignoreCaseFlag := flag.Bool("ignore-case", false, "Match <from> values regardless of ASCII letter case")
+	regexFlag := flag.Bool("regex", false, "Treat <from> values as regular expressions and <to> values as templates ($1 expands to the first group)")
+	policyFlag := flag.String("policy", strictPolicy.name, "Input validation policy: strict or relaxed")
+	unsafeFlag := flag.String("unsafe", "", "Disable input validation entirely; must be set to \""+unsafeAcknowledgement+"\"")
+	allowEmptyFlag := flag.Bool("allow-empty", false, "Allow <to> values shorter than the policy minimum, including empty ones")
+	flag.BoolVar(&jsonAware, "json", false, "Decode JSON documents inside values before replacing, and encode them again in their original style")
+	flag.BoolVar(&base64Aware, "base64", false, "Replace inside base64 encoded PHP serialized payloads")
+	dialectFlag := flag.String("dialect", dialectAuto, "Dump dialect: auto, mysql, postgres or sqlite")
+	escapingFlag := flag.String("escaping", "", "Escaping of values, if not the one of the input format: none, mysql or postgres")
+	diffFlag := flag.String("diff", "", "Write the changes made to every line to this file, - for stderr")
+	formatFlag := flag.String("format", formatSQL, "Input format: sql, csv, tsv, wxr or jsonl")
+	keysFlag := flag.String("keys", "", "Comma separated top level keys whose values are replaced in jsonl input; all keys if empty")
+	delimiterFlag := flag.String("delimiter", "", "Field delimiter of csv and tsv input, if not the default comma or tab")
+	rulesFlag := flag.String("rules", "", "Read <from> <to> pairs from this file, one tab separated pair per line")
+	strictFlag := flag.Bool("strict", false, "Exit with status 4 if a <to> value already occurred in the input before replacing, and with status 6 as soon as a line fails with an internal error")
+	verifyFlag := flag.Bool("verify", false, "Check that serialized data keeps its shape and only changes by the replacements; exit with status 5 if not")
+	charsetFlag := flag.String("charset", "", "Convert the text to UTF-8 while replacing: latin1, or double-encoded to undo UTF-8 encoded twice")
+	validateUTF8Flag := flag.Bool("validate-utf8", false, "Report invalid UTF-8 sequences in the input, by line and byte")
+	manifestFlag := flag.String("manifest", "", "Write a JSON manifest with SHA-256 checksums of the input and output, the rules and statistics to this file")
+	undoFlag := flag.String("undo", "", "Write rules that reverse this run to this file, with warnings where reversing is not lossless")
 	flag.Parse()
 
 	if *versionFlag {
@@ -54,14 +102,82 @@ func main() {
 		return
 	}
 
-	if len(os.Args) < 3 {
-		fmt.Fprintln(os.Stderr, "Usage: search-replace <from> <to>")
+	args := flag.Args()
+	if *rulesFlag != "" {
+		rules, err := readRules(*rulesFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+			return
+		}
+		args = append(args, rules...)
+	}
+
+	// converting the character set is a run of its own, replacing is optional
+	if len(args) < 2 && *charsetFlag == "" {
+		fmt.Fprintln(os.Stderr, "Usage: search-replace [options] <from> <to>")
+		os.Exit(1)
+		return
+	}
+
+	policy, ok := policies[*policyFlag]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown validation policy %q, use strict or relaxed\n", *policyFlag)
+		os.Exit(1)
+		return
+	}
+
+	if !dialects[*dialectFlag] {
+		fmt.Fprintf(os.Stderr, "Unknown dialect %q, use auto, mysql, postgres or sqlite\n", *dialectFlag)
+		os.Exit(1)
+		return
+	}
+
+	switch *formatFlag {
+	case formatSQL, formatCSV, formatTSV, formatWXR, formatJSONL:
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown format %q, use sql, csv, tsv, wxr or jsonl\n", *formatFlag)
+		os.Exit(1)
+		return
+	}
+
+	esc, ok := escapings[*escapingFlag]
+	if *escapingFlag != "" && !ok {
+		fmt.Fprintf(os.Stderr, "Unknown escaping %q, use none, mysql or postgres\n", *escapingFlag)
+		os.Exit(1)
+		return
+	}
+
+	if *charsetFlag != "" {
+		convertCharset, ok = charsetConversions[*charsetFlag]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown charset %q, use latin1 or double-encoded\n", *charsetFlag)
+			os.Exit(1)
+			return
+		}
+	}
+
+	if len(*delimiterFlag) > 1 {
+		fmt.Fprintf(os.Stderr, "The delimiter must be a single byte, not %q\n", *delimiterFlag)
 		os.Exit(1)
 		return
 	}
 
+	if *unsafeFlag != "" {
+		if *unsafeFlag != unsafeAcknowledgement {
+			fmt.Fprintf(os.Stderr, "-unsafe switches off all input validation and can corrupt the output; set it to %q to confirm\n", unsafeAcknowledgement)
+			os.Exit(1)
+			return
+		}
+		fmt.Fprintln(os.Stderr, "Warning: input validation is disabled")
+		policy = unsafePolicy
+	}
+
+	if *allowEmptyFlag {
+		policy = policy.withMinOutLength(0)
+	}
+
 	var replacements []*Replacement
-	args := os.Args[1:]
 
 	if len(args)%2 > 0 {
 		fmt.Fprintln(os.Stderr, "All replacements must have a <from> and <to> value")
@@ -72,35 +188,153 @@ func main() {
 	var from, to string
 	for i := 0; i < len(args)/2; i++ {
 		from = args[i*2]
-		if !validInput(from, minInLength) {
-			fmt.Fprintln(os.Stderr, "Invalid <from> URL, minimum length is 4")
+		to = args[(i*2)+1]
+
+		if *regexFlag {
+			pattern, err := compilePattern(from, *ignoreCaseFlag)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid <from> pattern %q: %s\n", from, err)
+				os.Exit(2)
+				return
+			}
+
+			if err := policy.checkTemplate(to); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid <to> %q: %s\n", to, err)
+				os.Exit(3)
+				return
+			}
+			if err := checkTemplateRefs(to, pattern); err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid <to> %q: %s\n", to, err)
+				os.Exit(3)
+				return
+			}
+
+			replacements = append(replacements, &Replacement{
+				From:    []byte(from),
+				To:      []byte(to),
+				Pattern: pattern,
+			})
+			continue
+		}
+
+		if err := policy.checkFrom(from); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid <from> %q: %s\n", from, err)
 			os.Exit(2)
 			return
 		}
 
-		to = args[(i*2)+1]
-		if !validInput(to, minOutLength) {
-			fmt.Fprintln(os.Stderr, "Invalid <to>, minimum length is 2")
+		if err := policy.checkTo(to); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid <to> %q: %s\n", to, err)
 			os.Exit(3)
 			return
 		}
 
-		replacements = append(replacements, &Replacement{
-			From: []byte(from),
-			To:   []byte(to),
-		})
+		for _, variant := range idnVariants(from, to) {
+			replacements = append(replacements, &Replacement{
+				From:       []byte(variant[0]),
+				To:         []byte(variant[1]),
+				IgnoreCase: *ignoreCaseFlag,
+			})
+		}
+	}
+
+	var manifestFile *os.File
+	var inputDigest, outputDigest *digest
+	var stdin io.Reader = os.Stdin
+	var stdout io.Writer = os.Stdout
+	if *manifestFlag != "" {
+		var err error
+		manifestFile, err = os.Create(*manifestFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+			return
+		}
+		defer manifestFile.Close()
+
+		inputDigest, outputDigest = newDigest(), newDigest()
+		stdin = io.TeeReader(os.Stdin, inputDigest)
+		stdout = io.MultiWriter(os.Stdout, outputDigest)
+	}
+
+	input := bufio.NewReaderSize(stdin, 2*1024*1024)
+
+	var r unitReader
+	fix := fixLineWithEscaping
+	values := valuesFunc(sqlValues)
+	switch *formatFlag {
+	case formatSQL:
+		if esc == noneEscaping {
+			r = &rawReader{r: input}
+		} else {
+			dump := newDumpReader(input, *dialectFlag)
+			if dump.dialect == dialectSQLite && esc == nil {
+				fix = fixSQLiteStatement
+				values = sqliteValues
+			}
+			r = dump
+		}
+	case formatWXR:
+		r = &wxrReader{r: input}
+		fix = fixWXRUnit
+		values = wxrValues
+	case formatJSONL:
+		format := newJSONLFormat(*keysFlag)
+		r = &lineReader{r: input, esc: noneEscaping}
+		fix = format.fixRecord
+		values = format.values
+	default:
+		var delimiter byte
+		if *delimiterFlag != "" {
+			delimiter = (*delimiterFlag)[0]
+		}
+		format := newCSVFormat(*formatFlag, delimiter)
+		r = &csvReader{r: input, format: format}
+		fix = format.fixRecord
+		values = format.values
+	}
+
+	if esc != nil {
+		r = &escapingReader{r: r, esc: esc}
+	}
+
+	var diff *bufio.Writer
+	switch *diffFlag {
+	case "":
+	case "-":
+		diff = bufio.NewWriter(os.Stderr)
+	default:
+		diffFile, err := os.Create(*diffFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+			return
+		}
+		defer diffFile.Close()
+		diff = bufio.NewWriter(diffFile)
+	}
+
+	var undo *os.File
+	if *undoFlag != "" {
+		var err error
+		undo, err = os.Create(*undoFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+			return
+		}
+		defer undo.Close()
 	}
 
 	var wg sync.WaitGroup
-	lines := make(chan chan []byte, 10)
+	lines := make(chan chan fixedUnit, 10)
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 
-		r := bufio.NewReaderSize(os.Stdin, 2*1024*1024)
 		for {
-			line, err := r.ReadBytes('\n')
+			line, esc, err := r.next()
 
 			if err != nil {
 				if err == io.EOF {
@@ -114,14 +348,29 @@ func main() {
 			}
 
 			wg.Add(1)
-			ch := make(chan []byte)
+			ch := make(chan fixedUnit)
 			lines <- ch
 
-			go func(line *[]byte) {
+			go func(line *[]byte, esc *escaping) {
 				defer wg.Done()
-				line = fixLine(line, replacements)
-				ch <- *line
-			}(&line)
+				original := *line
+				defer recoverUnit(original, ch)
+
+				countExisting(original, replacements)
+				line = fix(line, replacements, esc)
+
+				unit := fixedUnit{original: original, fixed: *line}
+				if *verifyFlag && !bytes.Equal(original, *line) {
+					unit.verifyErr = verifyUnit(values(original, esc), values(*line, esc), replacements)
+				}
+				if *validateUTF8Flag {
+					unit.invalidUTF8 = invalidUTF8(original)
+					if len(unit.invalidUTF8) > 0 {
+						stats.invalidUTF8Units.Add(1)
+					}
+				}
+				ch <- unit
+			}(&line, esc)
 		}
 	}()
 
@@ -130,20 +379,131 @@ func main() {
 		close(lines)
 	}()
 
+	lineNumber := 1
+	units, changed, verifyFailures := 0, 0, 0
 	for line := range lines {
-		fmt.Print(unsafeGetString(<-line))
+		unit := <-line
+
+		if unit.fixErr != nil {
+			fmt.Fprintf(os.Stderr, "Internal error on line %d: %s\n  %s\n", lineNumber, unit.fixErr, excerpt(unit.original))
+			if *strictFlag {
+				fmt.Fprintln(os.Stderr, "Failing because of -strict: a line could not be processed")
+				if diff != nil {
+					diff.Flush()
+				}
+				os.Exit(6)
+				return
+			}
+			fmt.Fprintln(os.Stderr, "  the line is written unchanged")
+		}
+
+		fmt.Fprint(stdout, unsafeGetString(unit.fixed))
+
+		units++
+		if !bytes.Equal(unit.original, unit.fixed) {
+			changed++
+		}
+
+		if unit.verifyErr != nil {
+			fmt.Fprintf(os.Stderr, "Verification failed on line %d: %s\n", lineNumber, unit.verifyErr)
+			verifyFailures++
+		}
+
+		if len(unit.invalidUTF8) > 0 {
+			reportInvalidUTF8(os.Stderr, lineNumber, unit.original, unit.invalidUTF8)
+		}
+
+		if diff != nil && !bytes.Equal(unit.original, unit.fixed) {
+			writeDiff(diff, lineNumber, unit.original, unit.fixed)
+		}
+		lineNumber += bytes.Count(unit.original, []byte("\n"))
+	}
+
+	if diff != nil {
+		diff.Flush()
+	}
+
+	if undo != nil {
+		warnings := undoWarnings(replacements)
+		if err := writeUndo(undo, replacements, warnings); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+			return
+		}
+
+		if len(warnings) == 0 {
+			fmt.Fprintf(os.Stderr, "Undo rules written to %s\n", *undoFlag)
+		} else {
+			fmt.Fprintf(os.Stderr, "Undo rules written to %s, reversing them is not lossless:\n", *undoFlag)
+			for _, warning := range warnings {
+				fmt.Fprintf(os.Stderr, "  %s\n", warning)
+			}
+		}
+	}
+
+	printSummary(os.Stderr, replacements)
+
+	if manifestFile != nil {
+		m := newManifest(inputDigest, outputDigest, replacements, units, changed, verifyFailures)
+		if err := m.write(manifestFile); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+			return
+		}
+	}
+
+	if verifyFailures > 0 {
+		fmt.Fprintf(os.Stderr, "Verification failed for %d lines\n", verifyFailures)
+		os.Exit(5)
+		return
+	}
+
+	if *strictFlag && len(existingTargets(replacements)) > 0 {
+		fmt.Fprintln(os.Stderr, "Failing because of -strict: <to> values already occurred in the input")
+		os.Exit(4)
+		return
 	}
 }
 
 func fixLine(line *[]byte, replacements []*Replacement) *[]byte {
+	return fixLineWithEscaping(line, replacements, mysqlEscaping)
+}
+
+// fixLineWithEscaping is fixLine for a line whose strings are escaped with esc
+func fixLineWithEscaping(line *[]byte, replacements []*Replacement, esc *escaping) *[]byte {
 	linePart := *line
 
 	var rebuiltLine []byte
 
+	// the next custom object is looked for once and remembered until the scan
+	// passes it; searching the rest of the line for every serialized string
+	// would make long extended INSERT lines quadratic
+	var custom []int
+	customSearched := false
+
 	for len(linePart) > 0 {
-		result, err := fixLineWithSerializedData(linePart, replacements)
+		offset := len(*line) - len(linePart)
+		if !customSearched || custom != nil && custom[0] < offset {
+			custom = esc.customPrefix.FindSubmatchIndex(linePart)
+			for i := range custom {
+				custom[i] += offset
+			}
+			customSearched = true
+		}
+
+		var next []int
+		if custom != nil {
+			next = make([]int, len(custom))
+			for i := range custom {
+				next[i] = custom[i] - offset
+			}
+		}
+
+		result, err := fixLineWithSerializedData(linePart, next, replacements, esc)
 		if err != nil {
-			rebuiltLine = append(rebuiltLine, linePart...)
+			// faulty serialized data is left alone, but a dump in two
+			// character sets would be worse than a length that stays wrong
+			rebuiltLine = append(rebuiltLine, convertText(linePart)...)
 			break
 		}
 		rebuiltLine = append(rebuiltLine, result.Pre...)
@@ -156,24 +516,163 @@ func fixLine(line *[]byte, replacements []*Replacement) *[]byte {
 	return line
 }
 
-func replaceByPart(part []byte, replacements []*Replacement) []byte {
+func replaceByPart(part []byte, replacements []*Replacement, esc *escaping) []byte {
+	if jsonAware {
+		return replaceJSONAware(part, replacements, esc)
+	}
+	return replacePlain(part, replacements, esc)
+}
+
+// replacePlain applies the replacements to part, which is escaped with esc;
+// raw data uses noneEscaping
+func replacePlain(part []byte, replacements []*Replacement, esc *escaping) []byte {
+	return replaceConverted(convertText(part), replacements, esc)
+}
+
+// replaceConverted is replacePlain for text that went through the -charset
+// conversion already, such as JSON strings decoded from converted text
+func replaceConverted(part []byte, replacements []*Replacement, esc *escaping) []byte {
+	if base64Aware {
+		return replaceAroundBase64(part, replacements, esc)
+	}
+	return applyReplacements(part, replacements, esc)
+}
+
+func applyReplacements(part []byte, replacements []*Replacement, esc *escaping) []byte {
 	for _, replacement := range replacements {
-		part = bytes.ReplaceAll(part, replacement.From, replacement.To)
+		part = replacement.apply(part, esc)
 	}
 	return part
 }
 
-var serializedStringPrefixRegexp = regexp.MustCompile(`s:(\d+):\\"`)
+// apply replaces every occurrence of From in part, which is escaped with esc,
+// with To
+func (r *Replacement) apply(part []byte, esc *escaping) []byte {
+	if r.Pattern != nil {
+		if esc == noneEscaping {
+			return r.Pattern.ReplaceAll(part, r.To)
+		}
+		return r.replacePattern(part, esc)
+	}
+
+	// A short or empty To shrinks the data around it, so a backslash in front
+	// of From would end up escaping whatever follows the match instead
+	shortTo := len(r.To) < minOutLength
+
+	if r.IgnoreCase || shortTo {
+		replaced, skipped := replaceMatches(part, r.From, r.To, r.IgnoreCase, shortTo)
+		r.escapedMatches.Add(int64(skipped))
+		return replaced
+	}
+	return bytes.ReplaceAll(part, r.From, r.To)
+}
+
+// replacePattern matches the pattern against the unescaped text, so a match
+// can't start or end inside an escape sequence, and writes the expanded
+// templates back escaped in place of the escaped matches
+func (r *Replacement) replacePattern(part []byte, esc *escaping) []byte {
+	raw, offsets := esc.unescapeWithOffsets(part)
+	matches := r.Pattern.FindAllSubmatchIndex(raw, -1)
+	if matches == nil {
+		return part
+	}
+
+	var rebuilt []byte
+	last := 0
+	for _, match := range matches {
+		start, end := offsets[match[0]], offsets[match[1]]
+		rebuilt = append(rebuilt, part[last:start]...)
+		rebuilt = append(rebuilt, esc.escape(r.Pattern.Expand(nil, r.To, raw, match))...)
+		last = end
+	}
+
+	return append(rebuilt, part[last:]...)
+}
+
+// replaceMatches is bytes.ReplaceAll with two options. fold enables ASCII-only
+// case folding: only A-Z and a-z are folded, so a match always has exactly the
+// byte length of old and serialized string lengths stay correct when the match
+// is replaced. skipEscaped leaves matches alone that follow an odd number of
+// backslashes, i.e. whose first byte is escaped, and returns how many it left.
+func replaceMatches(s, old, new []byte, fold, skipEscaped bool) ([]byte, int) {
+	if len(old) == 0 {
+		return append([]byte{}, s...), 0
+	}
+
+	haystack, needle := s, old
+	if fold {
+		haystack, needle = asciiLower(s), asciiLower(old)
+	}
+
+	var replaced []byte
+	matched := false
+	skipped := 0
+	start, searchFrom := 0, 0
+	for {
+		i := bytes.Index(haystack[searchFrom:], needle)
+		if i < 0 {
+			break
+		}
+		i += searchFrom
 
-func fixLineWithSerializedData(linePart []byte, replacements []*Replacement) (*SerializedReplaceResult, error) {
+		if skipEscaped && escapedAt(s, i) {
+			skipped++
+			searchFrom = i + 1
+			continue
+		}
+
+		replaced = append(replaced, s[start:i]...)
+		replaced = append(replaced, new...)
+		start = i + len(old)
+		searchFrom = start
+		matched = true
+	}
+
+	if !matched {
+		return append([]byte{}, s...), skipped
+	}
+
+	return append(replaced, s[start:]...), skipped
+}
+
+// escapedAt reports whether s[i] follows an odd number of backslashes. An even
+// number are escaped backslashes themselves and leave s[i] as it is.
+func escapedAt(s []byte, i int) bool {
+	backslashes := 0
+	for j := i - 1; j >= 0 && s[j] == '\\'; j-- {
+		backslashes++
+	}
+	return backslashes%2 == 1
+}
+
+func asciiLower(s []byte) []byte {
+	lower := make([]byte, len(s))
+	for i, c := range s {
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		lower[i] = c
+	}
+	return lower
+}
+
+// fixLineWithSerializedData fixes the first serialized string or custom object
+// in linePart. custom is the match of the next custom object in linePart, nil
+// if there is none.
+func fixLineWithSerializedData(linePart []byte, custom []int, replacements []*Replacement, esc *escaping) (*SerializedReplaceResult, error) {
 
 	// find starting point in the line
 	// We're not checking if we found the serialized string prefix inside a quote or not.
 	// Currently skipping that scenario because it seems unlikely to find it outside.
-	match := serializedStringPrefixRegexp.FindSubmatchIndex(linePart)
+	match := esc.stringPrefix.FindSubmatchIndex(linePart)
+
+	if custom != nil && (match == nil || custom[0] < match[0]) {
+		return fixCustomObject(linePart, custom, replacements, esc)
+	}
+
 	if match == nil {
 		return &SerializedReplaceResult{
-			Pre:               replaceByPart(linePart, replacements),
+			Pre:               replaceByPart(linePart, replacements, esc),
 			SerializedPortion: []byte{},
 			Post:              []byte{},
 		}, nil
@@ -181,7 +680,7 @@ func fixLineWithSerializedData(linePart []byte, replacements []*Replacement) (*S
 
 	pre := append([]byte{}, linePart[:match[0]]...)
 
-	pre = replaceByPart(pre, replacements)
+	pre = replaceByPart(pre, replacements, esc)
 
 	if pre == nil {
 		pre = []byte{}
@@ -191,11 +690,9 @@ func fixLineWithSerializedData(linePart []byte, replacements []*Replacement) (*S
 
 	originalByteSize, _ := strconv.Atoi(string(originalBytes))
 
-	// the following assumes escaped double quotes
-	// i.e. s:5:\"x -> we'll need to shift our index from '5' to 'x' - hence shifting by 3
-	// MySQL can optionally not escape the double quote,
-	// but generally sqldumps always include the quotes.
-	contentStartIndex := match[3] + 3
+	// the prefix ends with the (escaped) double quote, i.e. s:5:\"x for MySQL,
+	// so the content starts right after the match
+	contentStartIndex := match[1]
 
 	currentContentIndex := contentStartIndex
 
@@ -205,67 +702,61 @@ func fixLineWithSerializedData(linePart []byte, replacements []*Replacement) (*S
 
 	var nextSliceIndex int
 
-	backslash := byte('\\')
-	semicolon := byte(';')
-	quote := byte('"')
+	terminator := append(append([]byte{}, esc.quote...), ';')
 	nextSliceFound := false
 
-	maxIndex := len(linePart) - 1
-
 	// let's find where the content actually ends.
 	// it should end when the unescaped value is `";`
 	for currentContentIndex < len(linePart) {
-		if currentContentIndex+2 > maxIndex {
+		if currentContentIndex+len(terminator) > len(linePart) {
 
 			// this algorithm SHOULD work, but in cases where the original byte count does not match
 			// the actual byte count, it'll error out. We'll add this safeguard here.
 			return nil, fmt.Errorf("faulty serialized data: out-of-bound index access detected")
 		}
-		char := linePart[currentContentIndex]
-		secondChar := linePart[currentContentIndex+1]
-		thirdChar := linePart[currentContentIndex+2]
-		if char == backslash && contentByteCount < originalByteSize {
-			unescapedBytePair := getUnescapedBytesIfEscaped(linePart[currentContentIndex : currentContentIndex+2])
-			// if we get the byte pair without the backslash, it corresponds to a byte
-			contentByteCount += len(unescapedBytePair)
 
-			// content index count remains the same.
-			currentContentIndex += 2
+		if contentByteCount < originalByteSize {
+			// an escape sequence can stand for fewer bytes than it takes up in the line
+			width, unescaped := esc.decode(linePart[currentContentIndex:])
+			contentByteCount += len(unescaped)
+			currentContentIndex += width
 			continue
 		}
 
-		if char == backslash && secondChar == quote && thirdChar == semicolon && contentByteCount >= originalByteSize {
+		if contentByteCount == originalByteSize && bytes.HasPrefix(linePart[currentContentIndex:], terminator) {
 
-			// we're at backslash
+			// we're at the quote
 
 			// index of the beginning of the next slice
-			nextSliceIndex = currentContentIndex + 3
-			// we're at backslash, so we need to minus 1 to get the index where the content finishes
+			nextSliceIndex = currentContentIndex + len(terminator)
+			// we're at the quote, so we need to minus 1 to get the index where the content finishes
 			contentEndIndex = currentContentIndex - 1
 			nextSliceFound = true
 			break
 		}
 
-		if contentByteCount > originalByteSize {
-			return nil, fmt.Errorf("faulty serialized data: calculated byte count does not match given data size")
-		}
+		// the content is longer than the length says, or an escape sequence
+		// goes past the end of it
+		return nil, fmt.Errorf("faulty serialized data: calculated byte count does not match given data size")
+	}
 
-		contentByteCount++
-		currentContentIndex++
+	if !nextSliceFound {
+		return nil, fmt.Errorf("faulty serialized data: end of serialized data not found")
 	}
 
 	content := append([]byte{}, linePart[contentStartIndex:contentEndIndex+1]...)
 
-	content = replaceByPart(content, replacements)
+	content = replaceSerializedContent(content, replacements, esc)
 
-	contentLength := len(unescapeContent(content))
+	// the length is written as it was when it didn't change, which keeps
+	// unusual forms like leading zeros
+	length := string(originalBytes)
+	if contentLength := len(esc.unescape(content)); contentLength != originalByteSize {
+		length = strconv.Itoa(contentLength)
+	}
 
 	// and we rebuild the string
-	rebuiltSerializedString := "s:" + strconv.Itoa(contentLength) + ":\\\"" + string(content) + "\\\";"
-
-	if nextSliceFound == false {
-		return nil, fmt.Errorf("faulty serialized data: end of serialized data not found")
-	}
+	rebuiltSerializedString := "s:" + length + ":" + string(esc.quote) + string(content) + string(terminator)
 
 	result := SerializedReplaceResult{
 		Pre:               pre,
@@ -276,6 +767,95 @@ func fixLineWithSerializedData(linePart []byte, replacements []*Replacement) (*S
 	return &result, nil
 }
 
+// fixCustomObject rewrites the custom serialized object found at match. Its
+// payload is fixed as a whole, so the payload length changes along with the
+// strings inside it.
+func fixCustomObject(linePart []byte, match []int, replacements []*Replacement, esc *escaping) (*SerializedReplaceResult, error) {
+	pre := replaceByPart(append([]byte{}, linePart[:match[0]]...), replacements, esc)
+	if pre == nil {
+		pre = []byte{}
+	}
+
+	class := linePart[match[4]:match[5]]
+	classSize, _ := strconv.Atoi(string(linePart[match[2]:match[3]]))
+	if classSize != len(esc.unescape(class)) {
+		return nil, fmt.Errorf("faulty serialized data: class name length does not match given size")
+	}
+	payloadSize, _ := strconv.Atoi(string(linePart[match[6]:match[7]]))
+
+	payloadStart := match[1]
+	payloadEnd, err := skipUnescapedBytes(linePart, payloadStart, payloadSize, esc)
+	if err != nil {
+		return nil, err
+	}
+	if payloadEnd >= len(linePart) || linePart[payloadEnd] != '}' {
+		return nil, fmt.Errorf("faulty serialized data: end of custom serialized object not found")
+	}
+
+	payload := linePart[payloadStart:payloadEnd]
+	raw := esc.unescape(payload)
+	rewritten := replaceCustomPayload(raw, replacements)
+	if !bytes.Equal(rewritten, raw) {
+		payload = esc.escape(rewritten)
+	}
+
+	size := string(linePart[match[6]:match[7]])
+	if len(rewritten) != payloadSize {
+		size = strconv.Itoa(len(rewritten))
+	}
+
+	quote := string(esc.quote)
+	rebuilt := "C:" + string(linePart[match[2]:match[3]]) + ":" + quote + string(class) + quote + ":" +
+		size + ":{" + string(payload) + "}"
+
+	return &SerializedReplaceResult{
+		Pre:               pre,
+		SerializedPortion: []byte(rebuilt),
+		Post:              linePart[payloadEnd+1:],
+	}, nil
+}
+
+// skipUnescapedBytes returns the index in linePart after size unescaped bytes,
+// counted from start
+func skipUnescapedBytes(linePart []byte, start, size int, esc *escaping) (int, error) {
+	index := start
+	count := 0
+	for count < size {
+		if index >= len(linePart) {
+			return 0, fmt.Errorf("faulty serialized data: out-of-bound index access detected")
+		}
+		width, unescaped := esc.decode(linePart[index:])
+		count += len(unescaped)
+		index += width
+	}
+
+	if count != size {
+		return 0, fmt.Errorf("faulty serialized data: calculated byte count does not match given data size")
+	}
+
+	return index, nil
+}
+
+// replaceSerializedContent applies the replacements to the content of a
+// serialized string as it appears in the line. When the content is serialized
+// data itself, the inner lengths are fixed before the outer one is recomputed.
+func replaceSerializedContent(content []byte, replacements []*Replacement, esc *escaping) []byte {
+	raw := esc.unescape(content)
+	if !isSerializedStart(raw) {
+		return replaceByPart(content, replacements, esc)
+	}
+
+	nested, ok := rewriteNested(raw, replacements)
+	if !ok {
+		return replaceByPart(content, replacements, esc)
+	}
+	if bytes.Equal(nested, raw) {
+		return content
+	}
+
+	return esc.escape(nested)
+}
+
 func getUnescapedBytesIfEscaped(charPair []byte) []byte {
 
 	backslash := byte('\\')
@@ -298,9 +878,8 @@ func getUnescapedBytesIfEscaped(charPair []byte) []byte {
 		'0':  '\x00',
 	}
 
-	actualByte := unescapedMap[charPair[1]]
-
-	if actualByte != 0 {
+	// \0 maps to a NUL byte, so check for presence rather than a non-zero value
+	if actualByte, ok := unescapedMap[charPair[1]]; ok {
 		return []byte{actualByte}
 	}
 
@@ -308,37 +887,6 @@ func getUnescapedBytesIfEscaped(charPair []byte) []byte {
 	return charPair
 }
 
-func unescapeContent(escaped []byte) []byte {
-	unescapedBytes := make([]byte, 0, len(escaped))
-	index := 0
-
-	// only applies to content of a string - do not apply to raw mysql query
-	// tested with php -i, mysql client, and mysqldump and mydumper.
-	// 1. mysql translates certain bytes to `\<char>` i.e. `\n`. So these needs unescaping to get the correct byte length. See `getUnescapedBytesIfEscaped`
-	// 2. PHP serialize does not convert raw bytes into `\<char>` - they're as-is, so we don't need to take into account of escaped value in byte length calculation.
-
-	backslash := byte('\\')
-
-	for index < len(escaped) {
-
-		if escaped[index] == backslash {
-			unescapedBytePair := getUnescapedBytesIfEscaped(escaped[index : index+2])
-			byteLength := len(unescapedBytePair)
-
-			if byteLength == 1 {
-				unescapedBytes = append(unescapedBytes, unescapedBytePair...)
-				index = index + 2
-				continue
-			}
-		}
-
-		unescapedBytes = append(unescapedBytes, escaped[index])
-		index++
-	}
-
-	return unescapedBytes
-}
-
 func replaceAndFix(line *[]byte, replacements []*Replacement) *[]byte {
 	for _, replacement := range replacements {
 		if !bytes.Contains(*line, replacement.From) {
@@ -390,19 +938,7 @@ func fix(match *[]byte) []byte {
 }
 
 func validInput(in string, length int) bool {
-	if len(in) < length {
-		return false
-	}
-
-	if !input.MatchString(in) {
-		return false
-	}
-
-	if bad.MatchString(in) {
-		return false
-	}
-
-	return true
+	return strictPolicy.check(in, length) == nil
 }
 
 func unsafeGetString(bs []byte) string {
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -96,6 +98,25 @@ func BenchmarkSerializedReplaceNew(b *testing.B) {
 	}
 }
 
+// BenchmarkLongExtendedInsert runs on a single INSERT of thousands of rows,
+// as mysqldump writes them, where scanning must stay linear in the line length
+func BenchmarkLongExtendedInsert(b *testing.B) {
+	row := `(1,'widget_text','a:2:{i:2;a:2:{s:5:\"title\";s:4:\"Crew\";s:4:\"text\";s:29:\"http://automattic.com/crew/10\";}s:12:\"_multiwidget\";i:1;}','yes'),`
+	line := []byte("INSERT INTO `wp_options` VALUES " + strings.Repeat(row, 20000) + "(2,'home','http://automattic.com','yes');\n")
+	replacements := []*Replacement{
+		{
+			From: []byte("http://automattic.com"),
+			To:   []byte("https://automattic.com"),
+		},
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		in := line
+		fixLine(&in, replacements)
+	}
+}
+
 func TestReplace(t *testing.T) {
 	var tests = []struct {
 		testName string
@@ -224,6 +245,114 @@ func TestReplace(t *testing.T) {
 			in:  []byte(`s:20:\"aaaaabbbbbbbbbbaaaaa\";`),
 			out: []byte(`s:25:\"aaaaacccccccccccccccaaaaa\";`),
 		},
+		{
+			testName: "string one byte longer than its length is left alone",
+
+			from: []byte("http://automattic.com"),
+			to:   []byte("https://automattic.com"),
+
+			in:  []byte(`('s:20:\"http://automattic.com\";','http://automattic.com')`),
+			out: []byte(`('s:20:\"http://automattic.com\";','http://automattic.com')`),
+		},
+		{
+			testName: "string one byte shorter than its length is left alone",
+
+			from: []byte("http://automattic.com"),
+			to:   []byte("https://automattic.com"),
+
+			in:  []byte(`('s:22:\"http://automattic.com\";','http://automattic.com')`),
+			out: []byte(`('s:22:\"http://automattic.com\";','http://automattic.com')`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			replaced := fixLine(&test.in, []*Replacement{
+				{
+					From: test.from,
+					To:   test.to,
+				},
+			})
+
+			if !bytes.Equal(*replaced, test.out) {
+				t.Error("Expected:", string(test.out), "Actual:", string(*replaced))
+			}
+		})
+	}
+}
+
+// Serialized lengths count bytes, whatever the bytes are
+func TestMultibyteReplace(t *testing.T) {
+	var tests = []struct {
+		testName string
+		in       []byte
+		out      []byte
+		from     []byte
+		to       []byte
+	}{
+		{
+			testName: "multibyte from and to",
+
+			from: []byte("https://例え.jp"),
+			to:   []byte("https://ヘルプ.example"),
+
+			in:  []byte(`s:27:\"https://例え.jp/ページ\";`),
+			out: []byte(`s:35:\"https://ヘルプ.example/ページ\";`),
+		},
+		{
+			testName: "emoji around the URL",
+
+			from: []byte("https://uss-enterprise.com"),
+			to:   []byte("https://ncc-1701-d.space"),
+
+			in:  []byte(`s:36:\"🚀 https://uss-enterprise.com 🖖\";`),
+			out: []byte(`s:34:\"🚀 https://ncc-1701-d.space 🖖\";`),
+		},
+		{
+			testName: "emoji in to",
+
+			from: []byte("https://uss-enterprise.com"),
+			to:   []byte("https://uss-enterprise.com/🚀"),
+
+			in:  []byte(`s:26:\"https://uss-enterprise.com\";`),
+			out: []byte(`s:31:\"https://uss-enterprise.com/🚀\";`),
+		},
+		{
+			testName: "invalid UTF-8",
+
+			from: []byte("https://uss-enterprise.com"),
+			to:   []byte("https://ncc-1701-d.space"),
+
+			in:  []byte("s:32:\\\"\xff\xfe https://uss-enterprise.com \xe6\x97\\\";"),
+			out: []byte("s:30:\\\"\xff\xfe https://ncc-1701-d.space \xe6\x97\\\";"),
+		},
+		{
+			testName: "latin1",
+
+			from: []byte("https://uss-enterprise.com"),
+			to:   []byte("https://ncc-1701-d.space"),
+
+			in:  []byte("s:31:\\\"Caf\xe9 https://uss-enterprise.com\\\";"),
+			out: []byte("s:29:\\\"Caf\xe9 https://ncc-1701-d.space\\\";"),
+		},
+		{
+			testName: "mojibake",
+
+			from: []byte("https://uss-enterprise.com"),
+			to:   []byte("https://ncc-1701-d.space"),
+
+			in:  []byte(`s:34:\"CafÃ© https://uss-enterprise.com\";`),
+			out: []byte(`s:32:\"CafÃ© https://ncc-1701-d.space\";`),
+		},
+		{
+			testName: "multibyte character escaped by the dump",
+
+			from: []byte("https://uss-enterprise.com"),
+			to:   []byte("https://ncc-1701-d.space"),
+
+			in:  []byte(`s:35:\"\"日本\" https://uss-enterprise.com\";`),
+			out: []byte(`s:33:\"\"日本\" https://ncc-1701-d.space\";`),
+		},
 	}
 
 	for _, test := range tests {
@@ -351,6 +480,11 @@ func TestInput(t *testing.T) {
 			in:       "automattic.com",
 			valid:    true,
 		},
+		{
+			testName: "Internationalized domain name",
+			in:       "https://müller.de",
+			valid:    true,
+		},
 		{
 			testName: "Short string",
 			in:       "s:",
@@ -377,3 +511,389 @@ func TestInput(t *testing.T) {
 		})
 	}
 }
+
+func TestReplaceIgnoreCase(t *testing.T) {
+	var tests = []struct {
+		testName string
+		in       []byte
+		out      []byte
+		from     []byte
+		to       []byte
+	}{
+		{
+			testName: "mixed case host",
+
+			from: []byte("example.com"),
+			to:   []byte("example.org"),
+
+			in:  []byte(`('http://Example.COM/about')`),
+			out: []byte(`('http://example.org/about')`),
+		},
+		{
+			testName: "mixed case host in serialized string",
+
+			from: []byte("http://example.com"),
+			to:   []byte("https://new.example.org"),
+
+			in:  []byte(`s:24:\"HTTP://Example.com/about\";`),
+			out: []byte(`s:29:\"https://new.example.org/about\";`),
+		},
+		{
+			testName: "multiple matches with different cases",
+
+			from: []byte("example.com"),
+			to:   []byte("ex.io"),
+
+			in:  []byte(`s:35:\"example.com EXAMPLE.COM eXaMpLe.CoM\";`),
+			out: []byte(`s:17:\"ex.io ex.io ex.io\";`),
+		},
+		{
+			testName: "non-ASCII letters are not folded",
+
+			from: []byte("müller.de"),
+			to:   []byte("example.com"),
+
+			in:  []byte(`s:10:\"MÜLLER.de\";`),
+			out: []byte(`s:10:\"MÜLLER.de\";`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			replaced := fixLine(&test.in, []*Replacement{
+				{
+					From:       test.from,
+					To:         test.to,
+					IgnoreCase: true,
+				},
+			})
+
+			if !bytes.Equal(*replaced, test.out) {
+				t.Error("Expected:", string(test.out), "Actual:", string(*replaced))
+			}
+		})
+	}
+}
+
+func TestReplaceWithEmptyTo(t *testing.T) {
+	var tests = []struct {
+		testName string
+		in       []byte
+		out      []byte
+		from     []byte
+		to       []byte
+		skipped  int64
+	}{
+		{
+			testName: "strip path prefix",
+
+			from: []byte("/blog"),
+			to:   []byte(""),
+
+			in:  []byte(`('https://example.com/blog/about')`),
+			out: []byte(`('https://example.com/about')`),
+		},
+		{
+			testName: "serialized string shrinks",
+
+			from: []byte("/blog"),
+			to:   []byte(""),
+
+			in:  []byte(`s:30:\"https://example.com/blog/about\";`),
+			out: []byte(`s:25:\"https://example.com/about\";`),
+		},
+		{
+			testName: "serialized string shrinks to zero",
+
+			from: []byte("/blog"),
+			to:   []byte(""),
+
+			in:  []byte(`a:2:{i:0;s:5:\"/blog\";i:1;s:10:\"/blog/blog\";}`),
+			out: []byte(`a:2:{i:0;s:0:\"\";i:1;s:0:\"\";}`),
+		},
+		{
+			testName: "one byte replacement",
+
+			from: []byte("/blog/"),
+			to:   []byte("/"),
+
+			in:  []byte(`s:30:\"https://example.com/blog/about\";`),
+			out: []byte(`s:25:\"https://example.com/about\";`),
+		},
+		{
+			testName: "match after an escaped backslash",
+
+			from: []byte("/blog"),
+			to:   []byte(""),
+
+			in:  []byte(`('https:\\/\\/example.com\\/blog\\/about','/blog')`),
+			out: []byte(`('https:\\/\\/example.com\\\\/about','')`),
+		},
+		{
+			testName: "match after an escaped backslash in serialized string",
+
+			from: []byte("/blog"),
+			to:   []byte(""),
+
+			in:  []byte(`s:33:\"https:\\/\\/example.com\\/blog /blog\";`),
+			out: []byte(`s:23:\"https:\\/\\/example.com\\ \";`),
+		},
+		{
+			testName: "escaped match is left alone",
+
+			from: []byte("n/blog"),
+			to:   []byte(""),
+
+			in:      []byte(`('x\n/blog','n/blog')`),
+			out:     []byte(`('x\n/blog','')`),
+			skipped: 1,
+		},
+		{
+			testName: "escaped match in serialized string is left alone",
+
+			from: []byte("n/blog"),
+			to:   []byte(""),
+
+			in:      []byte(`s:14:\"x\n/blog n/blog\";`),
+			out:     []byte(`s:8:\"x\n/blog \";`),
+			skipped: 1,
+		},
+		{
+			testName: "match after three backslashes is left alone",
+
+			from: []byte("n/blog"),
+			to:   []byte(""),
+
+			in:      []byte(`('x\\\n/blog')`),
+			out:     []byte(`('x\\\n/blog')`),
+			skipped: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			replacement := &Replacement{
+				From: test.from,
+				To:   test.to,
+			}
+			replaced := fixLine(&test.in, []*Replacement{replacement})
+
+			if !bytes.Equal(*replaced, test.out) {
+				t.Error("Expected:", string(test.out), "Actual:", string(*replaced))
+			}
+			if skipped := replacement.escapedMatches.Load(); skipped != test.skipped {
+				t.Error("Expected skipped:", test.skipped, "Actual:", skipped)
+			}
+		})
+	}
+}
+
+func TestNestedSerializedReplace(t *testing.T) {
+	var tests = []struct {
+		testName string
+		in       []byte
+		out      []byte
+	}{
+		{
+			testName: "serialized twice",
+			in:       []byte(`('s:43:\"a:1:{s:3:\"url\";s:19:\"https://example.com\";}\";')`),
+			out:      []byte(`('s:47:\"a:1:{s:3:\"url\";s:23:\"https://example.org/new\";}\";')`),
+		},
+		{
+			testName: "serialized three times",
+			in:       []byte(`('s:51:\"s:43:\"a:1:{s:3:\"url\";s:19:\"https://example.com\";}\";\";')`),
+			out:      []byte(`('s:55:\"s:47:\"a:1:{s:3:\"url\";s:23:\"https://example.org/new\";}\";\";')`),
+		},
+		{
+			testName: "inner data escaped with addslashes",
+			in:       []byte(`('s:47:\"a:1:{s:3:\\\"url\\\";s:19:\\\"https://example.com\\\";}\";')`),
+			out:      []byte(`('s:51:\"a:1:{s:3:\\\"url\\\";s:23:\\\"https://example.org/new\\\";}\";')`),
+		},
+		{
+			testName: "nested inside an array",
+			in:       []byte(`('a:2:{i:0;s:43:\"a:1:{s:3:\"url\";s:19:\"https://example.com\";}\";i:1;s:19:\"https://example.com\";}')`),
+			out:      []byte(`('a:2:{i:0;s:47:\"a:1:{s:3:\"url\";s:23:\"https://example.org/new\";}\";i:1;s:23:\"https://example.org/new\";}')`),
+		},
+		{
+			testName: "content that only looks serialized is replaced as text",
+			in:       []byte(`('s:23:\"a:https://example.com/a\";')`),
+			out:      []byte(`('s:27:\"a:https://example.org/new/a\";')`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			replaced := fixLine(&test.in, []*Replacement{
+				{
+					From: []byte("https://example.com"),
+					To:   []byte("https://example.org/new"),
+				},
+			})
+
+			if !bytes.Equal(*replaced, test.out) {
+				t.Error("Expected:", string(test.out), "Actual:", string(*replaced))
+			}
+		})
+	}
+}
+
+func TestObjectReplace(t *testing.T) {
+	var tests = []struct {
+		testName string
+		in       []byte
+		out      []byte
+	}{
+		{
+			testName: "protected property",
+			in:       []byte(`('O:8:\"stdClass\":1:{s:7:\"\0*\0home\";s:19:\"https://example.com\";}')`),
+			out:      []byte(`('O:8:\"stdClass\":1:{s:7:\"\0*\0home\";s:23:\"https://example.org/new\";}')`),
+		},
+		{
+			testName: "custom serialized object",
+			in:       []byte(`('C:11:\"ArrayObject\":58:{x:i:0;a:1:{s:3:\"url\";s:19:\"https://example.com\";};m:a:0:{}}')`),
+			out:      []byte(`('C:11:\"ArrayObject\":62:{x:i:0;a:1:{s:3:\"url\";s:23:\"https://example.org/new\";};m:a:0:{}}')`),
+		},
+		{
+			testName: "custom serialized object inside an array",
+			in:       []byte(`('a:2:{i:0;C:11:\"ArrayObject\":58:{x:i:0;a:1:{s:3:\"url\";s:19:\"https://example.com\";};m:a:0:{}}i:1;s:19:\"https://example.com\";}')`),
+			out:      []byte(`('a:2:{i:0;C:11:\"ArrayObject\":62:{x:i:0;a:1:{s:3:\"url\";s:23:\"https://example.org/new\";};m:a:0:{}}i:1;s:23:\"https://example.org/new\";}')`),
+		},
+		{
+			testName: "namespaced custom serialized object",
+			in:       []byte(`('C:10:\"App\\Assets\":19:{https://example.com}')`),
+			out:      []byte(`('C:10:\"App\\Assets\":23:{https://example.org/new}')`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			replaced := fixLine(&test.in, []*Replacement{
+				{
+					From: []byte("https://example.com"),
+					To:   []byte("https://example.org/new"),
+				},
+			})
+
+			if !bytes.Equal(*replaced, test.out) {
+				t.Error("Expected:", string(test.out), "Actual:", string(*replaced))
+			}
+		})
+	}
+}
+
+var fuzzReplacements = []*Replacement{
+	{
+		From: []byte("http://uss-enterprise.com"),
+		To:   []byte("https://ncc-1701-d.space"),
+	},
+}
+
+func FuzzFixLine(f *testing.F) {
+	f.Add(`('s:26:\"http://uss-enterprise.com/\";')`)
+	f.Add(`a:2:{s:3:\"key\";s:5:\"value\";s:3:\"css\";s:4:\"a\r\nb\";}`)
+	f.Add(`('C:11:\"ArrayObject\":21:{x:i:0;a:0:{};m:a:0:{}}')`)
+	f.Add(`s:5:\"abc\\`)
+	f.Add(`s:3:\"abcd\";`)
+	f.Add(`s:1:\"\\\";`)
+	f.Add("http://uss-enterprise.com\\")
+
+	f.Fuzz(func(t *testing.T, line string) {
+		in := []byte(line)
+		if fixed := fixLine(&in, nil); string(*fixed) != line {
+			t.Errorf("Expected %q to be left unchanged without replacements, got %q", line, *fixed)
+		}
+
+		in = []byte(line)
+		fixLine(&in, fuzzReplacements)
+
+		// the same bytes as the content of a serialized string get the length
+		// of the replaced content
+		raw := []byte(line)
+		if isSerializedStart(raw) {
+			return
+		}
+		replaced := bytes.ReplaceAll(raw, fuzzReplacements[0].From, fuzzReplacements[0].To)
+
+		in = []byte(fmt.Sprintf(`('s:%d:\"%s\";')`, len(raw), mysqlEscape(raw)))
+		expected := fmt.Sprintf(`('s:%d:\"%s\";')`, len(replaced), mysqlEscape(replaced))
+		if fixed := fixLine(&in, fuzzReplacements); string(*fixed) != expected {
+			t.Errorf("Expected %q, got %q", expected, *fixed)
+		}
+	})
+}
+
+// fuzzEscapings are the escapings the scanner works with, including the ones
+// that are only picked by a format
+var fuzzEscapings = []*escaping{mysqlEscaping, copyEscaping, standardEscaping, outfileEscaping, noneEscaping}
+
+func FuzzUnescape(f *testing.F) {
+	f.Add([]byte(`a\"b\\c\nd`))
+	f.Add([]byte(`trailing\`))
+	f.Add([]byte(`\x\`))
+	f.Add([]byte(`\101\x4g''`))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		for _, esc := range fuzzEscapings {
+			for index := 0; index < len(b); {
+				width, _ := esc.decode(b[index:])
+				if width < 1 || index+width > len(b) {
+					t.Fatalf("%s: decoding %q at %d took %d bytes", esc.name, b, index, width)
+				}
+				index += width
+			}
+
+			unescaped, offsets := esc.unescapeWithOffsets(b)
+			if !bytes.Equal(unescaped, esc.unescape(b)) {
+				t.Errorf("%s: unescape and unescapeWithOffsets disagree on %q", esc.name, b)
+			}
+			if len(unescaped) > len(b) {
+				t.Errorf("%s: unescaping %q made it longer: %q", esc.name, b, unescaped)
+			}
+			if len(offsets) != len(unescaped)+1 || offsets[len(unescaped)] != len(b) {
+				t.Errorf("%s: offsets of %q don't end at its length: %v", esc.name, b, offsets)
+			}
+			for i := 1; i < len(offsets); i++ {
+				if offsets[i] < offsets[i-1] {
+					t.Errorf("%s: offsets of %q go back: %v", esc.name, b, offsets)
+				}
+			}
+
+			if roundTrip := esc.unescape(esc.escape(b)); !bytes.Equal(roundTrip, b) {
+				t.Errorf("%s: expected %q after escaping and unescaping, got %q", esc.name, b, roundTrip)
+			}
+		}
+	})
+}
+
+func FuzzFixLineWithSerializedData(f *testing.F) {
+	f.Add([]byte("http://uss-enterprise.com/"))
+	f.Add([]byte("line\nbreak \\ \"quoted\" 'single' \x00\t"))
+	f.Add([]byte(`";s:3:"abc";`))
+	f.Add([]byte(`C:11:"ArrayObject":21:{x:i:0;a:0:{};m:a:0:{}}`))
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		for _, esc := range fuzzEscapings {
+			// any input, as the rest of a line
+			fixLineWithSerializedData(raw, esc.customPrefix.FindSubmatchIndex(raw), fuzzReplacements, esc)
+
+			// the same bytes as the content of a serialized string get the
+			// length of the replaced content
+			if isSerializedStart(raw) {
+				continue
+			}
+			replaced := bytes.ReplaceAll(raw, fuzzReplacements[0].From, fuzzReplacements[0].To)
+
+			quote := string(esc.quote)
+			line := []byte(fmt.Sprintf("s:%d:%s%s%s;", len(raw), quote, esc.escape(raw), quote))
+			expected := fmt.Sprintf("s:%d:%s%s%s;", len(replaced), quote, esc.escape(replaced), quote)
+
+			result, err := fixLineWithSerializedData(line, esc.customPrefix.FindSubmatchIndex(line), fuzzReplacements, esc)
+			if err != nil {
+				t.Fatalf("%s: %q: %v", esc.name, line, err)
+			}
+			if fixed := string(result.Pre) + string(result.SerializedPortion); fixed != expected || len(result.Post) > 0 {
+				t.Errorf("%s: expected %q, got %q followed by %q", esc.name, expected, fixed, result.Post)
+			}
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// runStats counts what happened during a run. Lines are processed
+// concurrently, so all counters are atomic.
+type runStats struct {
+	base64Payloads atomic.Int64
+	invalidRecords atomic.Int64
+	failedUnits    atomic.Int64
+
+	// invalidUTF8Units is only counted with -validate-utf8
+	invalidUTF8Units atomic.Int64
+}
+
+var stats runStats
+
+// printSummary reports the counters of the features that were enabled, and
+// the <to> values that were already in the input
+func printSummary(w io.Writer, replacements []*Replacement) {
+	if base64Aware {
+		fmt.Fprintf(w, "Base64 serialized payloads rewritten: %d\n", stats.base64Payloads.Load())
+	}
+	if invalid := stats.invalidRecords.Load(); invalid > 0 {
+		fmt.Fprintf(w, "Lines that are not JSON objects, left unchanged: %d\n", invalid)
+	}
+	if failed := stats.failedUnits.Load(); failed > 0 {
+		fmt.Fprintf(w, "Lines left unchanged because of an internal error: %d\n", failed)
+	}
+	if invalid := stats.invalidUTF8Units.Load(); invalid > 0 {
+		fmt.Fprintf(w, "Lines with invalid UTF-8: %d\n", invalid)
+	}
+	for _, replacement := range replacements {
+		if skipped := replacement.escapedMatches.Load(); skipped > 0 {
+			fmt.Fprintf(w, "Warning: %d matches of %q follow a backslash that escapes them and were left unchanged\n",
+				skipped, replacement.From)
+		}
+	}
+	for _, replacement := range existingTargets(replacements) {
+		fmt.Fprintf(w, "Warning: %q already occurred %d times before replacing %q, the replacement can't be told apart from it\n",
+			replacement.To, replacement.existing.Load(), replacement.From)
+	}
+}
+
+// existingTargets lists the rules whose <to> value already occurred in the
+// input before replacing, once per <to> value
+func existingTargets(replacements []*Replacement) []*Replacement {
+	var existing []*Replacement
+	seen := make(map[string]bool)
+	for _, replacement := range replacements {
+		to := string(replacement.To)
+		if replacement.existing.Load() > 0 && !seen[to] {
+			seen[to] = true
+			existing = append(existing, replacement)
+		}
+	}
+	return existing
+}
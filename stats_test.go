@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrintSummaryExistingTargets(t *testing.T) {
+	replacements := []*Replacement{
+		{From: []byte("http://uss-enterprise.com"), To: []byte("https://uss-enterprise.com")},
+		{From: []byte("http://www.uss-enterprise.com"), To: []byte("https://uss-enterprise.com")},
+		{From: []byte("uss-enterprise.com/decks"), To: []byte("uss-enterprise.com/bridge")},
+	}
+
+	countExisting([]byte("('http://uss-enterprise.com','https://uss-enterprise.com/sickbay')"), replacements)
+	countExisting([]byte("('https://uss-enterprise.com')"), replacements)
+
+	expected := "Warning: \"https://uss-enterprise.com\" already occurred 2 times before replacing \"http://uss-enterprise.com\", the replacement can't be told apart from it\n"
+
+	// other tests count invalid JSON Lines records
+	invalid := stats.invalidRecords.Swap(0)
+	defer stats.invalidRecords.Store(invalid)
+
+	var out bytes.Buffer
+	printSummary(&out, replacements)
+
+	if out.String() != expected {
+		t.Error("Expected:", expected, "Actual:", out.String())
+	}
+}
+
+func TestPrintSummaryEscapedMatches(t *testing.T) {
+	replacement := &Replacement{From: []byte("n/blog"), To: []byte("")}
+	line := []byte(`('x\n/blog','x\\\n/blog','x\\n/blog')`)
+	fixLine(&line, []*Replacement{replacement})
+
+	expected := "Warning: 2 matches of \"n/blog\" follow a backslash that escapes them and were left unchanged\n"
+
+	// other tests count invalid JSON Lines records
+	invalid := stats.invalidRecords.Swap(0)
+	defer stats.invalidRecords.Store(invalid)
+
+	var out bytes.Buffer
+	printSummary(&out, []*Replacement{replacement})
+
+	if out.String() != expected {
+		t.Error("Expected:", expected, "Actual:", out.String())
+	}
+}
@@ -0,0 +1,12 @@
+-- MySQL dump 10.13  Distrib 8.0.36, for Linux (x86_64)
+--
+-- Host: localhost    Database: wordpress
+-- ------------------------------------------------------
+/*!40101 SET NAMES utf8mb4 */;
+
+LOCK TABLES `wp_posts` WRITE;
+INSERT INTO `wp_posts` VALUES (30,'twentytwentyone','body { color: #123456;\r\nborder-bottom: none; }\r\nbody:after{ content: \"▼\"; }\r\ndiv.bg { background: url(\'https://uss-enterprise.com/wp-content/uploads/main-bg.gif\');\r\n  background-position: left center;\r\n    background-repeat: no-repeat; }','custom_css');
+UNLOCK TABLES;
+LOCK TABLES `wp_options` WRITE;
+INSERT INTO `wp_options` VALUES (400,'theme_mods_astra','a:3:{s:18:\"custom_css_post_id\";i:30;s:3:\"css\";s:239:\"body { color: #123456;\r\nborder-bottom: none; }\r\nbody:after{ content: \"▼\"; }\r\ndiv.bg { background: url(\'https://uss-enterprise.com/wp-content/uploads/main-bg.gif\');\r\n  background-position: left center;\r\n    background-repeat: no-repeat; }\";s:3:\"key\";s:5:\"value\";}'),(401,'astra-settings','a:3:{s:11:\"site-layout\";s:21:\"ast-full-width-layout\";s:24:\"header-bg-obj-responsive\";a:1:{s:7:\"desktop\";a:2:{s:16:\"background-image\";s:54:\"http://uss-enterprise.com/wp-content/uploads/stars.png\";s:17:\"background-repeat\";s:6:\"repeat\";}}s:10:\"custom-css\";s:61:\".hero{background:url(\"https://uss-enterprise.com/hero.jpg\")}\n\";}');
+UNLOCK TABLES;
@@ -0,0 +1,9 @@
+-- MySQL dump 10.13  Distrib 8.0.36, for Linux (x86_64)
+--
+-- Host: localhost    Database: wordpress
+-- ------------------------------------------------------
+/*!40101 SET NAMES utf8mb4 */;
+
+LOCK TABLES `wp_options` WRITE;
+INSERT INTO `wp_options` VALUES (500,'backup_paths','a:3:{s:7:\"windows\";s:24:\"C:\\Users\\picard\\backups\\\";s:3:\"unc\";s:26:\"\\\\uss-enterprise.com\\share\";s:4:\"site\";s:25:\"http://uss-enterprise.com\";}'),(501,'tricky_strings','a:5:{s:10:\"terminator\";s:29:\"ends with \";} and s:5:\"fake\";\";s:5:\"quote\";s:13:\"it\'s \"quoted\"\";s:3:\"url\";s:32:\"https://uss-enterprise.com/?q=\";\";s:3:\"nul\";s:3:\"a\0b\";s:3:\"tab\";s:7:\"col	col\";}'),(502,'plain_text','Line one\nLine two with https://uss-enterprise.com\\path\\'),(503,'empty_values','a:5:{s:0:\"\";s:0:\"\";s:4:\"none\";N;s:4:\"zero\";i:0;s:5:\"float\";d:0.5;s:3:\"url\";s:25:\"http://uss-enterprise.com\";}');
+UNLOCK TABLES;
@@ -0,0 +1,87 @@
+<?php
+// Generates the reference output of every case in this directory:
+//
+//   php generate.php
+//
+// Each *.sql file is a mysqldump snippet. Its quoted values are unescaped,
+// serialized data is unserialized, the rules in rules.txt are applied to every
+// string inside it with str_replace, and the data is serialized and escaped
+// again, the way WP-CLI's search-replace does it. The result is written next
+// to the input as *.golden, and the PHP version below is updated.
+//
+// Generated with PHP: none yet, the checked in golden files come from a port of
+// this script to Go and still have to be generated with PHP
+
+$rules = [];
+foreach (file(__DIR__ . '/rules.txt', FILE_IGNORE_NEW_LINES) as $line) {
+	if ($line === '' || $line[0] === '#') {
+		continue;
+	}
+	$rules[] = explode("\t", $line, 2);
+}
+
+function replace_string($value) {
+	global $rules;
+	foreach ($rules as [$from, $to]) {
+		$value = str_replace($from, $to, $value);
+	}
+	return $value;
+}
+
+function replace_deep($data) {
+	if (is_string($data)) {
+		$unserialized = @unserialize($data);
+		if ($unserialized !== false || $data === 'b:0;') {
+			$replaced = replace_deep($unserialized);
+			return $replaced === $unserialized ? $data : serialize($replaced);
+		}
+		return replace_string($data);
+	}
+
+	if (is_array($data)) {
+		$replaced = [];
+		foreach ($data as $key => $value) {
+			$replaced[is_string($key) ? replace_string($key) : $key] = replace_deep($value);
+		}
+		return $replaced;
+	}
+
+	if (is_object($data)) {
+		$replaced = clone $data;
+		foreach (get_object_vars($data) as $key => $value) {
+			$replaced->$key = replace_deep($value);
+		}
+		return $replaced;
+	}
+
+	return $data;
+}
+
+$unescapes = ['0' => "\0", 'n' => "\n", 'r' => "\r", 't' => "\t", 'Z' => "\x1a", 'b' => "\x08"];
+
+function unescape_mysql($value) {
+	global $unescapes;
+	return preg_replace_callback('/\\\\(.)/s', function ($m) use ($unescapes) {
+		return $unescapes[$m[1]] ?? $m[1];
+	}, $value);
+}
+
+function escape_mysql($value) {
+	return strtr($value, ["\\" => "\\\\", "'" => "\\'", '"' => '\\"', "\n" => "\\n", "\r" => "\\r", "\0" => "\\0"]);
+}
+
+foreach (glob(__DIR__ . '/*.sql') as $input) {
+	$output = '';
+	foreach (preg_split('/(\'(?:[^\'\\\\]|\\\\.)*\')/s', file_get_contents($input), -1, PREG_SPLIT_DELIM_CAPTURE) as $i => $part) {
+		if ($i % 2 === 0) {
+			$output .= replace_string($part);
+			continue;
+		}
+		$value = unescape_mysql(substr($part, 1, -1));
+		$output .= "'" . escape_mysql(replace_deep($value)) . "'";
+	}
+	file_put_contents(substr($input, 0, -strlen('.sql')) . '.golden', $output);
+}
+
+$script = file_get_contents(__FILE__);
+file_put_contents(__FILE__, preg_replace('/^\/\/ Generated with PHP: .*(\n\/\/ [^\n]*)*?(?=\n\n)/m', '// Generated with PHP: ' . PHP_VERSION, $script, 1));
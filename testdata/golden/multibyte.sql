@@ -0,0 +1,12 @@
+-- MySQL dump 10.13  Distrib 8.0.36, for Linux (x86_64)
+--
+-- Host: localhost    Database: wordpress
+-- ------------------------------------------------------
+/*!40101 SET NAMES utf8mb4 */;
+
+LOCK TABLES `wp_posts` WRITE;
+INSERT INTO `wp_posts` VALUES (21,'Café — naïve crew','<p>Bienvenue à bord! Visitez <a href=\"https://uss-enterprise.com/fr/café\">le café</a> 🚀</p>'),(22,'日本語のサイト','<p>エンタープライズ号へようこそ https://uss-enterprise.com/ja/ブリッジ</p>');
+UNLOCK TABLES;
+LOCK TABLES `wp_options` WRITE;
+INSERT INTO `wp_options` VALUES (300,'polylang','a:3:{s:12:\"default_lang\";s:2:\"fr\";s:7:\"domains\";a:2:{s:2:\"fr\";s:26:\"https://uss-enterprise.com\";s:2:\"ja\";s:29:\"https://uss-enterprise.com/ja\";}s:6:\"labels\";a:3:{s:2:\"fr\";s:9:\"Français\";s:2:\"ja\";s:9:\"日本語\";s:2:\"de\";s:7:\"Größe\";}}'),(301,'widget_text','a:2:{i:2;a:3:{s:5:\"title\";s:11:\"Ñandú ✓\";s:4:\"text\";s:54:\"«https://uss-enterprise.com/español» — ©2024 ™\";s:6:\"filter\";b:0;}s:12:\"_multiwidget\";i:1;}'),(302,'blog_tagline','s:27:\"Ζωή long and prosper ☮\";');
+UNLOCK TABLES;
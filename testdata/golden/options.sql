@@ -0,0 +1,9 @@
+-- MySQL dump 10.13  Distrib 8.0.36, for Linux (x86_64)
+--
+-- Host: localhost    Database: wordpress
+-- ------------------------------------------------------
+/*!40101 SET NAMES utf8mb4 */;
+
+LOCK TABLES `wp_options` WRITE;
+INSERT INTO `wp_options` VALUES (1,'siteurl','http://uss-enterprise.com','yes'),(2,'home','https://uss-enterprise.com','yes'),(3,'blogname','USS Enterprise','yes'),(33,'widget_text','a:2:{i:2;a:4:{s:5:\"title\";s:4:\"Crew\";s:4:\"text\";s:58:\"<a href=\"http://uss-enterprise.com/crew\">Meet the crew</a>\";s:6:\"filter\";b:1;s:6:\"visual\";b:1;}s:12:\"_multiwidget\";i:1;}','yes'),(96,'sidebars_widgets','a:3:{s:19:\"wp_inactive_widgets\";a:0:{}s:9:\"sidebar-1\";a:2:{i:0;s:6:\"text-2\";i:1;s:8:\"search-3\";}s:13:\"array_version\";i:3;}','yes'),(150,'theme_mods_twentytwentyone','a:6:{i:0;b:0;s:18:\"nav_menu_locations\";a:1:{s:7:\"primary\";i:4;}s:11:\"custom_logo\";i:12;s:12:\"header_image\";s:64:\"https://uss-enterprise.com/wp-content/uploads/2021/05/header.jpg\";s:17:\"header_image_data\";O:8:\"stdClass\":7:{s:13:\"attachment_id\";i:13;s:3:\"url\";s:64:\"https://uss-enterprise.com/wp-content/uploads/2021/05/header.jpg\";s:13:\"thumbnail_url\";s:72:\"https://uss-enterprise.com/wp-content/uploads/2021/05/header-150x150.jpg\";s:8:\"alt_text\";s:0:\"\";s:17:\"attachment_parent\";i:0;s:6:\"height\";i:1200;s:5:\"width\";i:2000;}s:16:\"background_color\";s:6:\"ffffff\";}','yes'),(200,'_transient_feed_mod','b:0;','no'),(201,'recently_edited','a:2:{i:0;s:57:\"/var/www/html/wp-content/themes/twentytwentyone/style.css\";i:1;s:0:\"\";}','no');
+UNLOCK TABLES;
@@ -0,0 +1,9 @@
+-- MySQL dump 10.13  Distrib 8.0.36, for Linux (x86_64)
+--
+-- Host: localhost    Database: wordpress
+-- ------------------------------------------------------
+/*!40101 SET NAMES utf8mb4 */;
+
+LOCK TABLES `wp_postmeta` WRITE;
+INSERT INTO `wp_postmeta` VALUES (10,5,'_wp_attached_file','2021/05/header.jpg'),(11,5,'_wp_attachment_metadata','a:6:{s:5:\"width\";i:2000;s:6:\"height\";i:1200;s:4:\"file\";s:18:\"2021/05/header.jpg\";s:8:\"filesize\";i:482133;s:5:\"sizes\";a:2:{s:6:\"medium\";a:5:{s:4:\"file\";s:18:\"header-300x180.jpg\";s:5:\"width\";i:300;s:6:\"height\";i:180;s:9:\"mime-type\";s:10:\"image/jpeg\";s:8:\"filesize\";i:14012;}s:9:\"thumbnail\";a:5:{s:4:\"file\";s:18:\"header-150x150.jpg\";s:5:\"width\";i:150;s:6:\"height\";i:150;s:9:\"mime-type\";s:10:\"image/jpeg\";s:8:\"filesize\";i:6120;}}s:10:\"image_meta\";a:12:{s:8:\"aperture\";s:1:\"0\";s:6:\"credit\";s:0:\"\";s:6:\"camera\";s:0:\"\";s:7:\"caption\";s:0:\"\";s:17:\"created_timestamp\";s:1:\"0\";s:9:\"copyright\";s:0:\"\";s:12:\"focal_length\";s:1:\"0\";s:3:\"iso\";s:1:\"0\";s:13:\"shutter_speed\";s:1:\"0\";s:5:\"title\";s:0:\"\";s:11:\"orientation\";s:1:\"0\";s:8:\"keywords\";a:0:{}}}'),(12,7,'_menu_item_url','http://uss-enterprise.com/decks/10'),(13,8,'_links_to','https://uss-enterprise.com/bridge?tab=crew&lang=en'),(14,9,'enclosure','https://uss-enterprise.com/wp-content/uploads/captains-log.mp3\n1048576\naudio/mpeg\n'),(15,9,'_oembed_2f1c','<iframe title=\"Captain\'s log\" src=\"https://uss-enterprise.com/embed/log\"></iframe>'),(16,11,'_redirect_settings','a:2:{s:7:\"enabled\";b:1;s:9:\"redirects\";s:200:\"a:2:{i:0;a:2:{s:6:\"source\";s:11:\"/old-bridge\";s:6:\"target\";s:32:\"http://uss-enterprise.com/bridge\";}i:1;a:2:{s:6:\"source\";s:12:\"/ten-forward\";s:6:\"target\";s:35:\"https://uss-enterprise.com/decks/10\";}}\";}');
+UNLOCK TABLES;
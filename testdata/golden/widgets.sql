@@ -0,0 +1,9 @@
+-- MySQL dump 10.13  Distrib 8.0.36, for Linux (x86_64)
+--
+-- Host: localhost    Database: wordpress
+-- ------------------------------------------------------
+/*!40101 SET NAMES utf8mb4 */;
+
+LOCK TABLES `wp_options` WRITE;
+INSERT INTO `wp_options` VALUES (40,'widget_custom_html','a:2:{i:3;a:2:{s:5:\"title\";s:15:\"Captain\'s \"log\"\";s:7:\"content\";s:93:\"<p>Stardate 41153.7</p>\n<a href=\'https://uss-enterprise.com/log\' class=\"log\">Read the log</a>\";}s:12:\"_multiwidget\";i:1;}','yes'),(41,'widget_media_image','a:2:{i:2;a:15:{s:4:\"size\";s:4:\"full\";s:5:\"width\";i:2000;s:6:\"height\";i:1200;s:7:\"caption\";s:0:\"\";s:3:\"alt\";s:10:\"The bridge\";s:9:\"link_type\";s:6:\"custom\";s:8:\"link_url\";s:32:\"http://uss-enterprise.com/bridge\";s:13:\"image_classes\";s:0:\"\";s:12:\"link_classes\";s:0:\"\";s:8:\"link_rel\";s:0:\"\";s:17:\"link_target_blank\";b:0;s:11:\"image_title\";s:0:\"\";s:13:\"attachment_id\";i:13;s:3:\"url\";s:64:\"https://uss-enterprise.com/wp-content/uploads/2021/05/header.jpg\";s:5:\"title\";s:0:\"\";}s:12:\"_multiwidget\";i:1;}','yes'),(42,'widget_nav_menu','a:2:{i:4;a:2:{s:5:\"title\";s:5:\"Decks\";s:8:\"nav_menu\";i:4;}s:12:\"_multiwidget\";i:1;}','yes'),(43,'widget_block','a:2:{i:5;a:1:{s:7:\"content\";s:171:\"<!-- wp:image {\"id\":13} -->\n<figure class=\"wp-block-image\"><img src=\"https://uss-enterprise.com/wp-content/uploads/2021/05/header.jpg\" alt=\"\"/></figure>\n<!-- /wp:image -->\";}s:12:\"_multiwidget\";i:1;}','yes');
+UNLOCK TABLES;
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// readRules reads a rules file: one <from> and <to> pair per line, separated
+// by a tab. Empty lines and lines starting with # are skipped.
+func readRules(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var args []string
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		from, to, ok := strings.Cut(line, "\t")
+		if !ok || strings.Contains(to, "\t") {
+			return nil, fmt.Errorf("line %d of %s is not <from><tab><to>", number, path)
+		}
+		args = append(args, from, to)
+	}
+
+	return args, scanner.Err()
+}
+
+// countExisting counts the occurrences of every <to> value in a unit before it
+// is fixed. Text that already looks like the result of a replacement would be
+// changed too when the replacement is reversed. Occurrences that are part of a
+// <from> match don't count, since replacing changes them anyway: replacing
+// https://example.com/blog with https://example.com leaves no
+// https://example.com behind that was there before.
+func countExisting(unit []byte, replacements []*Replacement) {
+	var lower []byte
+	search := func(value []byte, ignoreCase bool) ([]byte, []byte) {
+		if !ignoreCase {
+			return unit, value
+		}
+		if lower == nil {
+			lower = asciiLower(unit)
+		}
+		return lower, asciiLower(value)
+	}
+
+	// end[i] is the end of the last <from> match starting at or before i.
+	var end []int
+	for _, replacement := range replacements {
+		if replacement.Pattern != nil || len(replacement.From) == 0 {
+			continue
+		}
+		haystack, from := search(replacement.From, replacement.IgnoreCase)
+		for i := bytes.Index(haystack, from); i >= 0; {
+			if end == nil {
+				end = make([]int, len(unit)+1)
+			}
+			end[i] = max(end[i], i+len(from))
+			next := bytes.Index(haystack[i+len(from):], from)
+			if next < 0 {
+				break
+			}
+			i += len(from) + next
+		}
+	}
+	for i := 1; end != nil && i < len(end); i++ {
+		end[i] = max(end[i], end[i-1])
+	}
+
+	for _, replacement := range replacements {
+		if replacement.Pattern != nil || len(replacement.To) == 0 {
+			continue
+		}
+
+		var count int64
+		haystack, to := search(replacement.To, replacement.IgnoreCase)
+		for i := 0; ; {
+			next := bytes.Index(haystack[i:], to)
+			if next < 0 {
+				break
+			}
+			i += next
+			// An occurrence overlapping a <from> match is changed by it.
+			if end == nil || end[i+len(to)-1] <= i {
+				count++
+			}
+			i += len(to)
+		}
+		replacement.existing.Add(count)
+	}
+}
+
+// undoWarnings explains why reversing the replacements would not restore the
+// input exactly. No warnings means the reversal is lossless.
+func undoWarnings(replacements []*Replacement) []string {
+	var warnings []string
+
+	froms := make(map[string][]string)
+	for _, replacement := range replacements {
+		if replacement.Pattern == nil {
+			froms[string(replacement.To)] = append(froms[string(replacement.To)], string(replacement.From))
+		}
+	}
+
+	for i, replacement := range replacements {
+		from, to := string(replacement.From), string(replacement.To)
+
+		switch {
+		case replacement.Pattern != nil:
+			warnings = append(warnings, fmt.Sprintf("%q is a regular expression, its replacements can't be reversed", from))
+			continue
+		case to == "":
+			warnings = append(warnings, fmt.Sprintf("%q was removed, it can't be put back", from))
+			continue
+		}
+
+		if replacement.IgnoreCase {
+			warnings = append(warnings, fmt.Sprintf("%q was matched regardless of case, reversing restores every match spelled that way", from))
+		}
+
+		// the undo file has one rule per <to>, the one of the last replacement
+		if len(froms[to]) > 1 && froms[to][0] == from {
+			warnings = append(warnings, fmt.Sprintf("%q replaced each of %s, reversing restores all of them as %q", to, strings.Join(quoteAll(froms[to]), ", "), froms[to][len(froms[to])-1]))
+		}
+
+		if existing := replacement.existing.Load(); existing > 0 {
+			warnings = append(warnings, fmt.Sprintf("%q already occurred %d times before replacing, reversing changes those too", to, existing))
+		}
+
+		for _, later := range replacements[i+1:] {
+			if later.Pattern == nil && len(later.From) > 0 && bytes.Contains(replacement.To, later.From) {
+				warnings = append(warnings, fmt.Sprintf("%q was changed again by the rule for %q", to, later.From))
+			}
+		}
+
+		if err := strictPolicy.checkFrom(to); err != nil {
+			warnings = append(warnings, fmt.Sprintf("%q can't be a <from> value with the default policy: %s", to, err))
+		}
+	}
+
+	if convertCharset != nil {
+		warnings = append(warnings, "the text was converted to UTF-8, the conversion isn't reversed")
+	}
+
+	return warnings
+}
+
+// writeUndo writes a rules file that reverses the replacements, in reverse
+// order, with the warnings as comments. Rules for a <to> that is already
+// reversed are left out, since the first one replaces every occurrence.
+func writeUndo(w io.Writer, replacements []*Replacement, warnings []string) error {
+	b := bufio.NewWriter(w)
+	fmt.Fprintln(b, "# Reverses a search-replace run, use it with: search-replace -rules <this file>")
+	for _, warning := range warnings {
+		fmt.Fprintf(b, "# warning: %s\n", warning)
+	}
+
+	reversed := make(map[string]bool)
+	for i := len(replacements) - 1; i >= 0; i-- {
+		replacement := replacements[i]
+		if replacement.Pattern != nil || len(replacement.To) == 0 || reversed[string(replacement.To)] {
+			continue
+		}
+		reversed[string(replacement.To)] = true
+		fmt.Fprintf(b, "%s\t%s\n", replacement.To, replacement.From)
+	}
+
+	return b.Flush()
+}
+
+func quoteAll(values []string) []string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = fmt.Sprintf("%q", value)
+	}
+	return quoted
+}
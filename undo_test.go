@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestReadRules(t *testing.T) {
+	var tests = []struct {
+		testName string
+		rules    string
+		args     []string
+		err      bool
+	}{
+		{
+			testName: "pairs, comments and blank lines",
+			rules:    "# undo\n\nhttps://ncc-1701-d.space\thttps://uss-enterprise.com\r\nncc-1701-d\tuss-enterprise\n",
+			args:     []string{"https://ncc-1701-d.space", "https://uss-enterprise.com", "ncc-1701-d", "uss-enterprise"},
+		},
+		{
+			testName: "missing tab",
+			rules:    "https://ncc-1701-d.space https://uss-enterprise.com\n",
+			err:      true,
+		},
+		{
+			testName: "too many tabs",
+			rules:    "a\tb\tc\n",
+			err:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "rules.txt")
+			if err := os.WriteFile(path, []byte(test.rules), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			args, err := readRules(path)
+			if (err != nil) != test.err {
+				t.Fatal("Expected error:", test.err, "Actual:", err)
+			}
+			if strings.Join(args, "|") != strings.Join(test.args, "|") {
+				t.Error("Expected:", test.args, "Actual:", args)
+			}
+		})
+	}
+}
+
+func TestUndoWarnings(t *testing.T) {
+	var tests = []struct {
+		testName     string
+		replacements []*Replacement
+		input        string
+		warnings     []string
+	}{
+		{
+			testName: "lossless",
+			replacements: []*Replacement{
+				{From: []byte("https://uss-enterprise.com"), To: []byte("https://ncc-1701-d.space")},
+			},
+			input: "('https://uss-enterprise.com')",
+		},
+		{
+			testName: "<to> already in the input",
+			replacements: []*Replacement{
+				{From: []byte("http://uss-enterprise.com"), To: []byte("https://uss-enterprise.com")},
+			},
+			input: "('http://uss-enterprise.com','https://uss-enterprise.com/bridge','https://uss-enterprise.com')",
+			warnings: []string{
+				`"https://uss-enterprise.com" already occurred 2 times before replacing, reversing changes those too`,
+			},
+		},
+		{
+			testName: "<to> inside <from> matches",
+			replacements: []*Replacement{
+				{From: []byte("https://uss-enterprise.com/bridge"), To: []byte("https://uss-enterprise.com")},
+			},
+			input: "('https://uss-enterprise.com/bridge','https://uss-enterprise.com/bridge/viewscreen')",
+		},
+		{
+			testName: "<to> inside and outside <from> matches",
+			replacements: []*Replacement{
+				{From: []byte("HTTPS://USS-ENTERPRISE.COM/bridge"), To: []byte("https://uss-enterprise.com"), IgnoreCase: true},
+			},
+			input: "('https://uss-enterprise.com/bridge','https://USS-Enterprise.com/decks')",
+			warnings: []string{
+				`"HTTPS://USS-ENTERPRISE.COM/bridge" was matched regardless of case, reversing restores every match spelled that way`,
+				`"https://uss-enterprise.com" already occurred 1 times before replacing, reversing changes those too`,
+			},
+		},
+		{
+			testName: "regular expressions and removed values",
+			replacements: []*Replacement{
+				{From: []byte(`/decks/\d+`), To: []byte("/decks"), Pattern: regexp.MustCompile(`/decks/\d+`)},
+				{From: []byte("https://uss-enterprise.com"), To: []byte("")},
+			},
+			warnings: []string{
+				`"/decks/\\d+" is a regular expression, its replacements can't be reversed`,
+				`"https://uss-enterprise.com" was removed, it can't be put back`,
+			},
+		},
+		{
+			testName: "several rules with the same <to>",
+			replacements: []*Replacement{
+				{From: []byte("http://uss-enterprise.com"), To: []byte("https://ncc-1701-d.space")},
+				{From: []byte("https://uss-enterprise.com"), To: []byte("https://ncc-1701-d.space")},
+			},
+			warnings: []string{
+				`"https://ncc-1701-d.space" replaced each of "http://uss-enterprise.com", "https://uss-enterprise.com", reversing restores all of them as "https://uss-enterprise.com"`,
+			},
+		},
+		{
+			testName: "chained rules and case",
+			replacements: []*Replacement{
+				{From: []byte("uss-enterprise.com"), To: []byte("ncc-1701.com"), IgnoreCase: true},
+				{From: []byte("ncc-1701"), To: []byte("ncc-1701-d")},
+			},
+			warnings: []string{
+				`"uss-enterprise.com" was matched regardless of case, reversing restores every match spelled that way`,
+				`"ncc-1701.com" was changed again by the rule for "ncc-1701"`,
+			},
+		},
+		{
+			testName: "short <to>",
+			replacements: []*Replacement{
+				{From: []byte("uss-enterprise"), To: []byte("ds9")},
+			},
+			warnings: []string{
+				`"ds9" can't be a <from> value with the default policy: it is 3 bytes long, the strict policy requires at least 4`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			countExisting([]byte(test.input), test.replacements)
+
+			warnings := undoWarnings(test.replacements)
+			if strings.Join(warnings, "\n") != strings.Join(test.warnings, "\n") {
+				t.Error("Expected:", test.warnings, "Actual:", warnings)
+			}
+		})
+	}
+}
+
+func TestWriteUndo(t *testing.T) {
+	replacements := []*Replacement{
+		{From: []byte("http://uss-enterprise.com"), To: []byte("https://uss-enterprise.com")},
+		{From: []byte("uss-enterprise.com"), To: []byte("ncc-1701-d.space")},
+		{From: []byte(`/decks/\d+`), To: []byte("/decks"), Pattern: regexp.MustCompile(`/decks/\d+`)},
+	}
+
+	expected := "# Reverses a search-replace run, use it with: search-replace -rules <this file>\n" +
+		"# warning: something\n" +
+		"ncc-1701-d.space\tuss-enterprise.com\n" +
+		"https://uss-enterprise.com\thttp://uss-enterprise.com\n"
+
+	var out bytes.Buffer
+	if err := writeUndo(&out, replacements, []string{"something"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != expected {
+		t.Error("Expected:", expected, "Actual:", out.String())
+	}
+}
+
+func TestUndoIDNVariants(t *testing.T) {
+	var replacements []*Replacement
+	for _, variant := range idnVariants("müller.de", "example.com") {
+		replacements = append(replacements, &Replacement{From: []byte(variant[0]), To: []byte(variant[1])})
+	}
+
+	expected := "# Reverses a search-replace run, use it with: search-replace -rules <this file>\n" +
+		"# warning: \"example.com\" replaced each of \"müller.de\", \"xn--mller-kva.de\", reversing restores all of them as \"xn--mller-kva.de\"\n" +
+		"example.com\txn--mller-kva.de\n"
+
+	var out bytes.Buffer
+	if err := writeUndo(&out, replacements, undoWarnings(replacements)); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.String() != expected {
+		t.Error("Expected:", expected, "Actual:", out.String())
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// utf8Context is how many bytes are shown on each side of an invalid sequence
+const utf8Context = 20
+
+// invalidUTF8 returns the offsets where invalid UTF-8 starts in b. Invalid
+// bytes that follow each other count as one sequence.
+func invalidUTF8(b []byte) []int {
+	var offsets []int
+	for i, previous := 0, -1; i < len(b); {
+		r, size := utf8.DecodeRune(b[i:])
+		if r == utf8.RuneError && size == 1 {
+			if previous != i {
+				offsets = append(offsets, i)
+			}
+			previous = i + 1
+		}
+		i += size
+	}
+	return offsets
+}
+
+// reportInvalidUTF8 writes where the invalid sequences of a unit starting at
+// the given line are, by line and byte within that line, with the bytes
+// around them
+func reportInvalidUTF8(w io.Writer, line int, unit []byte, offsets []int) {
+	for _, offset := range offsets {
+		lineStart := bytes.LastIndexByte(unit[:offset], '\n') + 1
+
+		from := offset - utf8Context
+		if from < lineStart {
+			from = lineStart
+		}
+		to := offset + utf8Context
+		if end := bytes.IndexByte(unit[offset:], '\n'); end >= 0 && offset+end < to {
+			to = offset + end
+		}
+		if to > len(unit) {
+			to = len(unit)
+		}
+
+		fmt.Fprintf(w, "Invalid UTF-8 on line %d at byte %d: %q\n",
+			line+bytes.Count(unit[:offset], []byte("\n")), offset-lineStart+1, unit[from:to])
+	}
+}
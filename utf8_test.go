@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestInvalidUTF8(t *testing.T) {
+	var tests = []struct {
+		testName string
+		in       string
+		offsets  []int
+	}{
+		{
+			testName: "valid",
+			in:       "('Café 日本 🚀')",
+		},
+		{
+			testName: "latin1",
+			in:       "('Caf\xe9')",
+			offsets:  []int{5},
+		},
+		{
+			testName: "invalid bytes next to each other",
+			in:       "\xff\xfe ok \xff",
+			offsets:  []int{0, 6},
+		},
+		{
+			testName: "truncated character",
+			in:       "日本\xe6\x97",
+			offsets:  []int{6},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			if actual := invalidUTF8([]byte(test.in)); !reflect.DeepEqual(actual, test.offsets) {
+				t.Error("Expected:", test.offsets, "Actual:", actual)
+			}
+		})
+	}
+}
+
+func TestReportInvalidUTF8(t *testing.T) {
+	unit := []byte("('first',\n'Caf\xe9 au lait with a long description',\n'\xff')\n")
+
+	expected := "Invalid UTF-8 on line 8 at byte 5: \"'Caf\\xe9 au lait with a lon\"\n" +
+		"Invalid UTF-8 on line 9 at byte 2: \"'\\xff')\"\n"
+
+	var out bytes.Buffer
+	reportInvalidUTF8(&out, 7, unit, invalidUTF8(unit))
+
+	if out.String() != expected {
+		t.Error("Expected:", expected, "Actual:", out.String())
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+)
+
+const (
+	relaxedInputRe     = `^[\p{L}\p{M}\p{N}_\-\.:/~?=&%+@#!*\[\]$|^]+$`
+	relaxedMinInLength = 3
+
+	// unsafeAcknowledgement has to be passed to -unsafe to switch off validation
+	unsafeAcknowledgement = "yes-i-have-a-backup"
+)
+
+// validationPolicy decides which <from> and <to> values are accepted, and
+// explains why a value was rejected
+type validationPolicy struct {
+	name         string
+	minInLength  int
+	minOutLength int
+
+	// allowed matches values made only of permitted characters, allowedDesc
+	// lists those characters for error messages. A nil allowed permits anything.
+	allowed     *regexp.Regexp
+	allowedDesc string
+
+	// rejectMarkers rejects values that look like PHP serialization structure
+	rejectMarkers bool
+}
+
+var (
+	strictPolicy = &validationPolicy{
+		name:          "strict",
+		minInLength:   minInLength,
+		minOutLength:  minOutLength,
+		allowed:       input,
+		allowedDesc:   "letters, digits, _ - . : /",
+		rejectMarkers: true,
+	}
+
+	relaxedPolicy = &validationPolicy{
+		name:          "relaxed",
+		minInLength:   relaxedMinInLength,
+		minOutLength:  minOutLength,
+		allowed:       regexp.MustCompile(relaxedInputRe),
+		allowedDesc:   "letters, digits, _ - . : / ~ ? = & % + @ # ! * [ ] $ | ^",
+		rejectMarkers: true,
+	}
+
+	// unsafePolicy only refuses an empty <from>, which would match everywhere
+	unsafePolicy = &validationPolicy{
+		name:        "unsafe",
+		minInLength: 1,
+	}
+
+	policies = map[string]*validationPolicy{
+		strictPolicy.name:  strictPolicy,
+		relaxedPolicy.name: relaxedPolicy,
+	}
+)
+
+// withMinOutLength returns a copy of the policy with a different minimum
+// length for <to> values
+func (p *validationPolicy) withMinOutLength(length int) *validationPolicy {
+	copied := *p
+	copied.minOutLength = length
+	return &copied
+}
+
+// checkFrom explains why in can't be used as a <from> value, if it can't
+func (p *validationPolicy) checkFrom(in string) error {
+	return p.check(in, p.minInLength)
+}
+
+// checkTo explains why out can't be used as a <to> value, if it can't
+func (p *validationPolicy) checkTo(out string) error {
+	return p.check(out, p.minOutLength)
+}
+
+func (p *validationPolicy) check(in string, length int) error {
+	if len(in) < length {
+		return fmt.Errorf("it is %d bytes long, the %s policy requires at least %d", len(in), p.name, length)
+	}
+
+	if p.allowed != nil && !p.allowed.MatchString(in) {
+		for _, r := range in {
+			if !p.allowed.MatchString(string(r)) {
+				return fmt.Errorf("it contains %s, which the %s policy does not allow (allowed: %s)", describeRune(r), p.name, p.allowedDesc)
+			}
+		}
+	}
+
+	if p.rejectMarkers {
+		if marker := bad.FindString(in); marker != "" {
+			return fmt.Errorf("it contains %q, which looks like PHP serialization structure", marker)
+		}
+	}
+
+	return nil
+}
+
+// checkTemplate checks a regular expression replacement the same way a plain
+// <to> value is checked, once its $1 / ${name} references are taken out
+func (p *validationPolicy) checkTemplate(template string) error {
+	literal := templateRefRe.ReplaceAllString(template, "")
+	if literal == template {
+		return p.checkTo(template)
+	}
+
+	if literal == "" {
+		return nil
+	}
+
+	return p.check(literal, 0)
+}
+
+func describeRune(r rune) string {
+	switch {
+	case r == utf8.RuneError:
+		return "an invalid UTF-8 sequence"
+	case r == ' ':
+		return "a space"
+	case r < ' ' || r == 0x7f:
+		return fmt.Sprintf("the control character %U", r)
+	}
+	return fmt.Sprintf("%q", r)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidationPolicy(t *testing.T) {
+	var tests = []struct {
+		testName string
+		policy   *validationPolicy
+		in       string
+		to       bool
+		reason   string
+	}{
+		{
+			testName: "strict accepts a URL",
+			policy:   strictPolicy,
+			in:       "https://example.com/blog",
+		},
+		{
+			testName: "strict rejects a short <from>",
+			policy:   strictPolicy,
+			in:       "abc",
+			reason:   "it is 3 bytes long, the strict policy requires at least 4",
+		},
+		{
+			testName: "strict rejects a tilde",
+			policy:   strictPolicy,
+			in:       "https://example.com/~user",
+			reason:   `it contains '~', which the strict policy does not allow`,
+		},
+		{
+			testName: "strict rejects a space",
+			policy:   strictPolicy,
+			in:       "example com",
+			reason:   "it contains a space",
+		},
+		{
+			testName: "strict rejects serialization structure",
+			policy:   strictPolicy,
+			in:       "a:4:",
+			reason:   `it contains "a:4:", which looks like PHP serialization structure`,
+		},
+		{
+			testName: "relaxed accepts a query string",
+			policy:   relaxedPolicy,
+			in:       "https://example.com/?page_id=2&lang=en",
+		},
+		{
+			testName: "relaxed accepts a home directory path",
+			policy:   relaxedPolicy,
+			in:       "/~user/blog",
+		},
+		{
+			testName: "relaxed accepts a port",
+			policy:   relaxedPolicy,
+			in:       "localhost:8080",
+		},
+		{
+			testName: "relaxed accepts a short <from>",
+			policy:   relaxedPolicy,
+			in:       "/wp",
+		},
+		{
+			testName: "relaxed rejects a quote",
+			policy:   relaxedPolicy,
+			in:       "example.com'",
+			reason:   `it contains '\'', which the relaxed policy does not allow`,
+		},
+		{
+			testName: "relaxed rejects serialization structure",
+			policy:   relaxedPolicy,
+			in:       "s:12:",
+			reason:   "looks like PHP serialization structure",
+		},
+		{
+			testName: "unsafe accepts anything",
+			policy:   unsafePolicy,
+			in:       "'),(",
+		},
+		{
+			testName: "unsafe rejects an empty <from>",
+			policy:   unsafePolicy,
+			in:       "",
+			reason:   "it is 0 bytes long",
+		},
+		{
+			testName: "strict rejects a one byte <to>",
+			policy:   strictPolicy,
+			in:       "x",
+			to:       true,
+			reason:   "it is 1 bytes long, the strict policy requires at least 2",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			check := test.policy.checkFrom
+			if test.to {
+				check = test.policy.checkTo
+			}
+
+			err := check(test.in)
+			if test.reason == "" {
+				if err != nil {
+					t.Error("Expected no error, Actual:", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), test.reason) {
+				t.Error("Expected:", test.reason, "Actual:", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// valuesFunc returns the values of a unit as PHP would get them, with the
+// escaping and quoting of the input format removed
+type valuesFunc func(unit []byte, esc *escaping) [][]byte
+
+// sqlValues unescapes a whole line of a dump. The SQL around the values is
+// unescaped too, which doesn't matter when looking for serialized data.
+func sqlValues(unit []byte, esc *escaping) [][]byte {
+	return [][]byte{esc.unescape(unit)}
+}
+
+// verifyUnit checks that every serialized value in the original unit is still
+// there in the fixed unit, parses, has the same shape, and differs only where
+// the replacements changed a string
+func verifyUnit(original, fixed [][]byte, replacements []*Replacement) error {
+	var before, after []*phpValue
+	for _, value := range original {
+		before = append(before, serializedValues(value)...)
+	}
+	for _, value := range fixed {
+		after = append(after, serializedValues(value)...)
+	}
+
+	if len(before) != len(after) {
+		return fmt.Errorf("found %d serialized values before replacing and %d after", len(before), len(after))
+	}
+
+	for i := range before {
+		if err := compareSerialized(fmt.Sprintf("value %d", i+1), before[i], after[i], replacements); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// serializedValues finds the serialized strings, arrays and objects in raw
+func serializedValues(raw []byte) []*phpValue {
+	var values []*phpValue
+	for i := 0; i < len(raw); i++ {
+		if i > 0 && isWordByte(raw[i-1]) || !isSerializedStart(raw[i:]) || !bytes.ContainsRune([]byte("saOC"), rune(raw[i])) {
+			continue
+		}
+
+		value, n, err := parseSerialized(raw[i:])
+		if err != nil {
+			continue
+		}
+		values = append(values, value)
+		i += n - 1
+	}
+	return values
+}
+
+// compareSerialized explains how after differs from before with the
+// replacements applied, if it does
+func compareSerialized(path string, before, after *phpValue, replacements []*Replacement) error {
+	if before.kind != after.kind {
+		return fmt.Errorf("%s: type changed from %c to %c", path, before.kind, after.kind)
+	}
+	if !bytes.Equal(before.class, after.class) {
+		return fmt.Errorf("%s: class changed from %q to %q", path, before.class, after.class)
+	}
+
+	var expected []byte
+	switch before.kind {
+	case 's':
+		// the same as the line scanner does with the contents of a string
+		if nested, ok := rewriteNested(before.data, replacements); ok {
+			expected = nested
+		} else {
+			expected = replaceByPart(append([]byte{}, before.data...), replacements, noneEscaping)
+		}
+	case 'C':
+		expected = replaceCustomPayload(append([]byte{}, before.data...), replacements)
+	default:
+		expected = before.data
+	}
+	if !bytes.Equal(expected, after.data) {
+		return fmt.Errorf("%s: %q became %q, expected %q", path, before.data, after.data, expected)
+	}
+
+	if len(before.items) != len(after.items) {
+		return fmt.Errorf("%s: %d elements became %d", path, len(before.items)/2, len(after.items)/2)
+	}
+
+	for i := 0; i+1 < len(before.items); i += 2 {
+		key := before.items[i]
+		name := string(key.data)
+		if key.kind == 's' {
+			name = strconv.Quote(name)
+		}
+
+		if err := compareSerialized(path+" key "+name, key, after.items[i], replacements); err != nil {
+			return err
+		}
+		if err := compareSerialized(path+"["+name+"]", before.items[i+1], after.items[i+1], replacements); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func isWordByte(c byte) bool {
+	return isDigit(c) || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_'
+}
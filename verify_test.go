@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestVerifyUnit(t *testing.T) {
+	replacements := []*Replacement{
+		{
+			From: []byte("https://example.com"),
+			To:   []byte("https://example.org/new"),
+		},
+	}
+
+	var tests = []struct {
+		testName string
+		original string
+		fixed    string
+		err      string
+	}{
+		{
+			testName: "replaced and fixed",
+			original: `('a:2:{s:3:"url";s:19:"https://example.com";i:0;b:1;}','s:19:"https://example.com";')`,
+			fixed:    `('a:2:{s:3:"url";s:23:"https://example.org/new";i:0;b:1;}','s:23:"https://example.org/new";')`,
+		},
+		{
+			testName: "nested serialized data",
+			original: `('a:1:{i:0;s:30:"a:1:{i:0;s:19:"https://example.com";}";}')`,
+			fixed:    `('a:1:{i:0;s:34:"a:1:{i:0;s:23:"https://example.org/new";}";}')`,
+		},
+		{
+			testName: "length not fixed",
+			original: `('s:19:"https://example.com";')`,
+			fixed:    `('s:19:"https://example.org/new";')`,
+			err:      "found 1 serialized values before replacing and 0 after",
+		},
+		{
+			testName: "element added",
+			original: `('a:1:{i:0;s:19:"https://example.com";}')`,
+			fixed:    `('a:2:{i:0;s:23:"https://example.org/new";i:1;N;}')`,
+			err:      "value 1: 1 elements became 2",
+		},
+		{
+			testName: "other change",
+			original: `('a:1:{s:3:"url";s:19:"https://example.com";}')`,
+			fixed:    `('a:1:{s:3:"url";s:19:"https://example.net";}')`,
+			err:      `value 1["url"]: "https://example.com" became "https://example.net", expected "https://example.org/new"`,
+		},
+		{
+			testName: "type changed",
+			original: `('a:1:{i:0;i:1;}')`,
+			fixed:    `('a:1:{i:0;s:1:"1";}')`,
+			err:      "value 1[0]: type changed from i to s",
+		},
+		{
+			testName: "class changed",
+			original: `('O:3:"Foo":0:{}')`,
+			fixed:    `('O:3:"Bar":0:{}')`,
+			err:      `value 1: class changed from "Foo" to "Bar"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			err := verifyUnit([][]byte{[]byte(test.original)}, [][]byte{[]byte(test.fixed)}, replacements)
+
+			actual := ""
+			if err != nil {
+				actual = err.Error()
+			}
+			if actual != test.err {
+				t.Error("Expected:", test.err, "Actual:", actual)
+			}
+		})
+	}
+}
+
+func TestVerifyFixedLines(t *testing.T) {
+	replacements := []*Replacement{
+		{
+			From: []byte("https://example.com"),
+			To:   []byte("https://example.org/new"),
+		},
+	}
+
+	lines := []string{
+		`('a:2:{s:3:\"url\";s:19:\"https://example.com\";s:4:\"note\";s:6:\"a\nb\\c\'\";}')`,
+		`('a:1:{i:0;O:8:\"stdClass\":1:{s:4:\"home\";s:19:\"https://example.com\";}}','https://example.com')`,
+		`('C:11:\"ArrayObject\":52:{x:i:0;a:1:{i:0;s:19:\"https://example.com\";};m:a:0:{}}')`,
+	}
+
+	for _, line := range lines {
+		in := []byte(line)
+		fixed := fixLine(&in, replacements)
+
+		original := sqlValues([]byte(line), mysqlEscaping)
+		if err := verifyUnit(original, sqlValues(*fixed, mysqlEscaping), replacements); err != nil {
+			t.Error("Expected no error for", line, "Actual:", err)
+		}
+		if len(serializedValues(original[0])) == 0 {
+			t.Error("Expected serialized values in", line)
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+)
+
+const formatWXR = "wxr"
+
+var (
+	cdataStart = []byte("<![CDATA[")
+	cdataEnd   = []byte("]]>")
+
+	// WordPress splits a CDATA section where the data contains its end marker
+	cdataEndSplit = []byte("]]]]><![CDATA[>")
+
+	xmlEntities = map[string]byte{
+		"amp":  '&',
+		"lt":   '<',
+		"gt":   '>',
+		"quot": '"',
+		"apos": '\'',
+	}
+)
+
+// wxrReader splits a WordPress export (WXR) into units of character data, text
+// and CDATA sections, each followed by the markup (a tag, comment or
+// processing instruction) that ends it. Markup is never changed.
+type wxrReader struct {
+	r *bufio.Reader
+}
+
+func (w *wxrReader) next() ([]byte, *escaping, error) {
+	var unit []byte
+	for {
+		chunk, err := w.r.ReadBytes('<')
+		unit = append(unit, chunk...)
+		if err != nil {
+			return unit, noneEscaping, err
+		}
+
+		if rest, _ := w.r.Peek(len(cdataStart) - 1); bytes.Equal(rest, cdataStart[1:]) {
+			section, err := readThrough(w.r, cdataEnd)
+			unit = append(unit, section...)
+			if err != nil {
+				return unit, noneEscaping, err
+			}
+			continue
+		}
+
+		markup, err := w.readMarkup()
+		return append(unit, markup...), noneEscaping, err
+	}
+}
+
+// readMarkup reads the rest of the markup after its opening <
+func (w *wxrReader) readMarkup() ([]byte, error) {
+	if rest, _ := w.r.Peek(3); bytes.Equal(rest, []byte("!--")) {
+		return readThrough(w.r, []byte("-->"))
+	}
+	if rest, _ := w.r.Peek(1); bytes.Equal(rest, []byte("?")) {
+		return readThrough(w.r, []byte("?>"))
+	}
+
+	// a > inside a quoted attribute value doesn't end the tag
+	var markup []byte
+	var quote byte
+	for {
+		c, err := w.r.ReadByte()
+		if err != nil {
+			return markup, err
+		}
+		markup = append(markup, c)
+
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return markup, nil
+		}
+	}
+}
+
+// readThrough reads until the input ends with delim, delim included
+func readThrough(r *bufio.Reader, delim []byte) ([]byte, error) {
+	var read []byte
+	for {
+		chunk, err := r.ReadBytes(delim[len(delim)-1])
+		read = append(read, chunk...)
+		if err != nil || bytes.HasSuffix(read, delim) {
+			return read, err
+		}
+	}
+}
+
+// fixWXRUnit applies the replacements to the character data of a unit read by
+// wxrReader and fixes the serialized data in it, counting bytes as PHP sees
+// them once entities are decoded and CDATA sections are joined.
+func fixWXRUnit(unit *[]byte, replacements []*Replacement, esc *escaping) *[]byte {
+	content, markup := splitWXRUnit(*unit)
+
+	raw, hasCDATA := decodeXMLContent(content)
+	value := append([]byte{}, raw...)
+	fixed := *fixLineWithEscaping(&value, replacements, esc)
+	if bytes.Equal(fixed, raw) {
+		return unit
+	}
+
+	var rebuilt []byte
+	if hasCDATA {
+		rebuilt = append(rebuilt, cdataStart...)
+		rebuilt = append(rebuilt, bytes.ReplaceAll(fixed, cdataEnd, cdataEndSplit)...)
+		rebuilt = append(rebuilt, cdataEnd...)
+	} else {
+		rebuilt = encodeXMLText(fixed, content)
+	}
+	rebuilt = append(rebuilt, markup...)
+
+	return &rebuilt
+}
+
+// wxrValues returns the decoded character data of a unit
+func wxrValues(unit []byte, esc *escaping) [][]byte {
+	content, _ := splitWXRUnit(unit)
+	raw, _ := decodeXMLContent(content)
+	return [][]byte{esc.unescape(raw)}
+}
+
+// splitWXRUnit returns the character data of a unit and the markup after it
+func splitWXRUnit(unit []byte) ([]byte, []byte) {
+	for i := 0; i < len(unit); i++ {
+		if unit[i] != '<' {
+			continue
+		}
+		if !bytes.HasPrefix(unit[i:], cdataStart) {
+			return unit[:i], unit[i:]
+		}
+
+		end := bytes.Index(unit[i+len(cdataStart):], cdataEnd)
+		if end < 0 {
+			return unit, nil
+		}
+		i += len(cdataStart) + end + len(cdataEnd) - 1
+	}
+	return unit, nil
+}
+
+// decodeXMLContent decodes text and CDATA sections, and tells whether there
+// were any CDATA sections. Unknown entities are kept as they are.
+func decodeXMLContent(content []byte) ([]byte, bool) {
+	decoded := make([]byte, 0, len(content))
+	hasCDATA := false
+
+	for i := 0; i < len(content); i++ {
+		if bytes.HasPrefix(content[i:], cdataStart) {
+			hasCDATA = true
+			start := i + len(cdataStart)
+			end := bytes.Index(content[start:], cdataEnd)
+			if end < 0 {
+				return append(decoded, content[start:]...), hasCDATA
+			}
+			decoded = append(decoded, content[start:start+end]...)
+			i = start + end + len(cdataEnd) - 1
+			continue
+		}
+
+		if content[i] == '&' {
+			if end := bytes.IndexByte(content[i:], ';'); end > 1 {
+				if r, ok := decodeXMLEntity(string(content[i+1 : i+end])); ok {
+					decoded = append(decoded, r...)
+					i += end
+					continue
+				}
+			}
+		}
+
+		decoded = append(decoded, content[i])
+	}
+
+	return decoded, hasCDATA
+}
+
+func decodeXMLEntity(name string) ([]byte, bool) {
+	if c, ok := xmlEntities[name]; ok {
+		return []byte{c}, true
+	}
+
+	if len(name) < 2 || name[0] != '#' {
+		return nil, false
+	}
+
+	var code uint64
+	var err error
+	if name[1] == 'x' || name[1] == 'X' {
+		code, err = strconv.ParseUint(name[2:], 16, 32)
+	} else {
+		code, err = strconv.ParseUint(name[1:], 10, 32)
+	}
+	if err != nil {
+		return nil, false
+	}
+
+	return []byte(string(rune(code))), true
+}
+
+// encodeXMLText encodes text, and quotes too if original encoded them
+func encodeXMLText(raw []byte, original []byte) []byte {
+	quot := bytes.Contains(original, []byte("&quot;"))
+	apos := bytes.Contains(original, []byte("&apos;"))
+
+	encoded := make([]byte, 0, len(raw)+len(raw)/8)
+	for _, c := range raw {
+		switch {
+		case c == '&':
+			encoded = append(encoded, "&amp;"...)
+		case c == '<':
+			encoded = append(encoded, "&lt;"...)
+		case c == '>':
+			encoded = append(encoded, "&gt;"...)
+		case c == '"' && quot:
+			encoded = append(encoded, "&quot;"...)
+		case c == '\'' && apos:
+			encoded = append(encoded, "&apos;"...)
+		default:
+			encoded = append(encoded, c)
+		}
+	}
+	return encoded
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWXRReader(t *testing.T) {
+	in := "<?xml version=\"1.0\"?>\n" +
+		"<!-- <wp:meta_value> -->\n" +
+		"<a title=\"x > y\">Tom &amp; Jerry</a>\n" +
+		"<wp:meta_value><![CDATA[<b>]]]]><![CDATA[>]]></wp:meta_value>\n"
+
+	units := []string{
+		"<?xml version=\"1.0\"?>",
+		"\n<!-- <wp:meta_value> -->",
+		"\n<a title=\"x > y\">",
+		"Tom &amp; Jerry</a>",
+		"\n<wp:meta_value>",
+		"<![CDATA[<b>]]]]><![CDATA[>]]></wp:meta_value>",
+		"\n",
+	}
+
+	r := &wxrReader{r: bufio.NewReader(strings.NewReader(in))}
+
+	var actual []string
+	for {
+		unit, _, err := r.next()
+		if len(unit) > 0 {
+			actual = append(actual, string(unit))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if strings.Join(actual, "|") != strings.Join(units, "|") {
+		t.Error("Expected:", units, "Actual:", actual)
+	}
+}
+
+func TestFixWXRUnit(t *testing.T) {
+	var tests = []struct {
+		testName string
+		in       string
+		out      string
+	}{
+		{
+			testName: "serialized data in CDATA",
+			in:       `<![CDATA[a:1:{s:3:"url";s:19:"https://example.com";}]]></wp:meta_value>`,
+			out:      `<![CDATA[a:1:{s:3:"url";s:23:"https://example.org/new";}]]></wp:meta_value>`,
+		},
+		{
+			testName: "CDATA split around its end marker",
+			in:       `<![CDATA[a:2:{i:0;s:5:"x]]]]><![CDATA[>y";i:1;s:19:"https://example.com";}]]></wp:meta_value>`,
+			out:      `<![CDATA[a:2:{i:0;s:5:"x]]]]><![CDATA[>y";i:1;s:23:"https://example.org/new";}]]></wp:meta_value>`,
+		},
+		{
+			testName: "serialized data in text with entities",
+			in:       `a:1:{i:0;s:22:&quot;&#233;https://example.com&amp;&quot;;}</wp:meta_value>`,
+			out:      `a:1:{i:0;s:26:&quot;éhttps://example.org/new&amp;&quot;;}</wp:meta_value>`,
+		},
+		{
+			testName: "plain text",
+			in:       "Read https://example.com</title>",
+			out:      "Read https://example.org/new</title>",
+		},
+		{
+			testName: "markup is left alone",
+			in:       "\n<link href=\"https://example.com\">",
+			out:      "\n<link href=\"https://example.com\">",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			in := []byte(test.in)
+			replaced := fixWXRUnit(&in, []*Replacement{
+				{
+					From: []byte("https://example.com"),
+					To:   []byte("https://example.org/new"),
+				},
+			}, noneEscaping)
+
+			if !bytes.Equal(*replaced, []byte(test.out)) {
+				t.Error("Expected:", test.out, "Actual:", string(*replaced))
+			}
+		})
+	}
+}